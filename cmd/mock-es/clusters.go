@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clusterConfig is the identity and faults of a cluster served by the
+// mock.  The default cluster is configured by the flags, the clusters of
+// the clusters flag override them with the keys named after the flags.
+type clusterConfig struct {
+	ClusterUUID  string       `json:"clusteruuid"`
+	ClusterName  string       `json:"cluster-name"`
+	Version      string       `json:"es-version"`
+	HealthStatus string       `json:"health-status"`
+	Delay        jsonDuration `json:"delay"`
+	Duplicate    uint         `json:"dup"`
+	TooMany      uint         `json:"toomany"`
+	NonIndex     uint         `json:"nonindex"`
+	TooLarge     uint         `json:"toolarge"`
+	Bulk429      uint         `json:"bulk-429"`
+}
+
+// jsonDuration is a time.Duration written as a Go 'time.Duration' string
+// in JSON, eg "250ms"
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	pd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(pd)
+	return nil
+}
+
+// validate returns an error if c has the same invalid values the flags
+// are checked for
+func (c clusterConfig) validate() error {
+	if c.Duplicate+c.TooMany+c.NonIndex > 100 {
+		return fmt.Errorf("total of create action percentages must not be more than 100")
+	}
+	if c.TooLarge+c.Bulk429 > 100 {
+		return fmt.Errorf("total of toolarge and bulk-429 percentages must not be more than 100")
+	}
+	switch c.HealthStatus {
+	case "green", "yellow", "red":
+	default:
+		return fmt.Errorf("unknown health-status %q", c.HealthStatus)
+	}
+	return nil
+}
+
+// parseClusters returns the clusters of s, a JSON object keyed by host or
+// path prefix, whose settings default to those of def
+func parseClusters(s string, def clusterConfig) (map[string]clusterConfig, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, err
+	}
+	clusters := make(map[string]clusterConfig, len(raw))
+	for key, b := range raw {
+		if key == "" || key == "/" {
+			return nil, fmt.Errorf("cluster key %q must be a host or a path prefix", key)
+		}
+		c := def
+		// a misspelled key would leave the cluster with the defaults
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&c); err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", key, err)
+		}
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", key, err)
+		}
+		clusters[key] = c
+	}
+	return clusters, nil
+}
+
+// clusterKeyName returns the key of a cluster, a host or path prefix, as a
+// name for its metrics and files, eg leader for /leader
+func clusterKeyName(key string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(strings.TrimPrefix(key, "/"))
+}
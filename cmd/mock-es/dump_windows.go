@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+)
+
+// notifyDump does nothing, there is no signal for an on demand metrics
+// dump on windows
+func notifyDump(c chan<- os.Signal) {
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// loggingResponseWriter remembers the status and number of bytes written
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher if the wrapped ResponseWriter does
+func (lw *loggingResponseWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// loggingMiddleware logs every request with its body and response status.
+// logFormat is either "text" for log.Printf lines or "json" for slog JSON lines.
+// Bodies longer than bodyLimit bytes are truncated, a bodyLimit of 0 doesn't
+// log bodies and a negative bodyLimit logs them whole.
+func loggingMiddleware(next http.Handler, logFormat string, bodyLimit int) http.Handler {
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var body string
+		if bodyLimit != 0 {
+			rawBody, err := io.ReadAll(r.Body)
+			if err != nil {
+				log.Printf("error reading request body: %s", err)
+			}
+			r.Body = io.NopCloser(bytes.NewReader(rawBody))
+			var bodyReader io.Reader = bytes.NewReader(rawBody)
+			if encoding := r.Header.Get("Content-Encoding"); encoding == "gzip" || encoding == "deflate" || encoding == "zstd" {
+				zr, err := decompressor(encoding, rawBody)
+				if err != nil {
+					log.Printf("error decoding %s request body: %s", encoding, err)
+				} else {
+					defer zr.Close()
+					bodyReader = zr
+				}
+			}
+			body = readBody(bodyReader, bodyLimit)
+		}
+
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+		switch {
+		case lw.status != 0:
+		case r.Context().Err() != nil:
+			// the client gave up before anything was written
+			lw.status = 499
+		default:
+			lw.status = http.StatusOK
+		}
+
+		switch logFormat {
+		case "json":
+			attrs := []any{
+				slog.String("method", r.Method),
+				slog.String("uri", r.RequestURI),
+				slog.Int("status", lw.status),
+				slog.Int("bytes", lw.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("user_agent", r.UserAgent()),
+			}
+			if bodyLimit != 0 {
+				attrs = append(attrs, slog.String("body", body))
+			}
+			logger.Info("request", attrs...)
+		default:
+			if bodyLimit == 0 {
+				log.Printf("%s %s %d %d %s %q", r.Method, r.RequestURI, lw.status, lw.bytes, time.Since(start), r.UserAgent())
+				return
+			}
+			log.Printf("%s %s %d %d %s %q\n%s", r.Method, r.RequestURI, lw.status, lw.bytes, time.Since(start), r.UserAgent(), body)
+		}
+	})
+}
+
+// readBody reads a body to log from r, keeping limit bytes and noting how
+// many bytes were cut.  The bytes over limit are counted without being
+// kept, so a large or highly compressed body doesn't fill the memory.  A
+// negative limit doesn't truncate.
+func readBody(r io.Reader, limit int) string {
+	if limit < 0 {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			log.Printf("error reading request body: %s", err)
+		}
+		return string(b)
+	}
+	head, err := io.ReadAll(io.LimitReader(r, int64(limit)))
+	if err != nil {
+		log.Printf("error reading request body: %s", err)
+		return string(head)
+	}
+	cut, err := io.Copy(io.Discard, r)
+	if err != nil {
+		log.Printf("error reading request body: %s", err)
+	}
+	if cut == 0 {
+		return string(head)
+	}
+	return fmt.Sprintf("%s...(truncated %d bytes)", head, cut)
+}
+
+// decompressor returns a reader of b decompressed from encoding, gzip,
+// deflate or zstd
+func decompressor(encoding string, b []byte) (io.ReadCloser, error) {
+	var zr io.ReadCloser
+	var err error
+	switch encoding {
+	case "deflate":
+		zr, err = zlib.NewReader(bytes.NewReader(b))
+	case "zstd":
+		var zd *zstd.Decoder
+		zd, err = zstd.NewReader(bytes.NewReader(b), zstd.WithDecoderConcurrency(1))
+		if err == nil {
+			zr = zd.IOReadCloser()
+		}
+	default:
+		zr, err = gzip.NewReader(bytes.NewReader(b))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("new %s reader failed: %w", encoding, err)
+	}
+	return zr, nil
+}
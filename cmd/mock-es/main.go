@@ -1,17 +1,88 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/elastic/mock-es/pkg/api"
 	"github.com/google/uuid"
 	"github.com/rcrowley/go-metrics"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/time/rate"
 )
 
+// shutdownTimeout is how long to wait for requests to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// clientAuthTypes maps the -client-auth values to tls.ClientAuthType
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// headerFlag is a repeatable name=value flag collecting response headers
+type headerFlag http.Header
+
+func (hf headerFlag) String() string {
+	return fmt.Sprint(http.Header(hf))
+}
+
+func (hf headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("header %q is not name=value", s)
+	}
+	http.Header(hf).Add(name, value)
+	return nil
+}
+
+// warningFlag is a repeatable flag collecting warning messages
+type warningFlag []string
+
+func (wf *warningFlag) String() string {
+	return fmt.Sprint([]string(*wf))
+}
+
+func (wf *warningFlag) Set(s string) error {
+	*wf = append(*wf, s)
+	return nil
+}
+
+// attributeFlag is a repeatable key=value flag collecting resource attributes
+type attributeFlag map[string]string
+
+func (af attributeFlag) String() string {
+	return fmt.Sprint(map[string]string(af))
+}
+
+func (af attributeFlag) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("resource attribute %q is not key=value", s)
+	}
+	af[key] = value
+	return nil
+}
+
 var (
 	addr             string
 	expire           time.Time
@@ -19,58 +90,590 @@ var (
 	percentTooMany   uint
 	percentNonIndex  uint
 	percentTooLarge  uint
+	percentBulk429   uint
 	uid              uuid.UUID
 	clusterUUID      string
 	metricsInterval  time.Duration
+	otlpEndpoint     string
+	otlpTraces       string
+	maxUserAgents    int
+	indexMetrics     string
+	serviceVersion   string
+	resourceAttrs    = make(attributeFlag)
 	certFile         string
 	keyFile          string
 	delay            time.Duration
+	shards           int
+	rejectShard      int
+	replicas         int
+	percentShardFail uint
+	failingPipeline  string
+	noAutoCreate     bool
+	maxContentLength int64
+	streamBulk       bool
+	maxLineSize      int
+	coldStart        time.Duration
+	portFile         string
+	defaultStatus    int
+	publishAddress   string
+	sniffNodes       string
+	healthyFor       int64
+	failAfter        int64
+	pathFaultsJSON   string
+	pathFaults       map[string]api.PathFault
+	defaultBody      string
+	delayJitter      float64
+	drip             time.Duration
+	percentHang      uint
+	percentReset     uint
+	coldStartCount   int64
+	h2Scramble       time.Duration
+	strictBulk       bool
+	heapWatermark    int64
+	store            bool
+	historyCap       int
+	replayFile       string
+	upstream         string
+	deniedPrivileges string
+	historyFile      string
+	historyMaxSize   int64
+	retryAfter       time.Duration
+	rps              float64
+	useHTTP2         bool
+	clientCAFile     string
+	clientAuth       string
+	verbose          bool
+	logFormat        string
+	logBodyLimit     int
+	headers          = headerFlag{}
+	warnings         warningFlag
+	productHeader    string
+	clusterName      string
+	esVersion        string
+	healthStatus     string
+	licenseType      string
+	licenseStatus    string
+	licenseExpiry    string
+	licenseExpiresIn time.Duration
+	buildHash        string
+	inferMappings    bool
+	refreshInterval  time.Duration
+	reindexDuration  time.Duration
+	buildDate        string
+	luceneVersion    string
+	clustersJSON     string
+	defaultCluster   clusterConfig
+	clusters         map[string]clusterConfig
 )
 
 func init() {
-	flag.StringVar(&addr, "addr", ":9200", "address to listen on ip:port")
+	flag.StringVar(&addr, "addr", ":9200", "address to listen on ip:port, or unix:path for a Unix domain socket")
+	flag.StringVar(&portFile, "port-file", "", "file the address the server listens on is written to, useful with port 0, empty string is no file")
+	flag.StringVar(&publishAddress, "publish-address", "", "ip:port address returned to sniffing clients by /_nodes/http, empty string is the Host the request was sent to")
+	flag.StringVar(&sniffNodes, "sniff-nodes", "", "comma separated ip:port addresses returned to sniffing clients by /_nodes/http as distinct nodes, empty string is a single node at publish-address")
 	flag.UintVar(&percentDuplicate, "dup", 0, "percent chance StatusConflict is returned for create action")
 	flag.UintVar(&percentTooMany, "toomany", 0, "percent chance StatusTooManyRequests is returned for create action")
 	flag.UintVar(&percentNonIndex, "nonindex", 0, "percent chance StatusNotAcceptable is returned for create action")
 	flag.UintVar(&percentTooLarge, "toolarge", 0, "percent chance StatusEntityTooLarge is returned for POST method on _bulk endpoint")
+	flag.UintVar(&percentBulk429, "bulk-429", 0, "percent chance StatusTooManyRequests is returned for the whole request on _bulk endpoint, before any action is performed")
 	flag.StringVar(&clusterUUID, "clusteruuid", "", "Cluster UUID of Elasticsearch we are mocking")
+	flag.StringVar(&clusterName, "cluster-name", "mock", "Cluster name of Elasticsearch we are mocking, returned by /, /_cluster/health, /_cluster/stats, /_cat/health and /_nodes/http, the node name stays mock")
+	flag.StringVar(&esVersion, "es-version", "", "Elasticsearch version returned by /, empty string is the version of the client's User-Agent")
+	flag.StringVar(&clustersJSON, "clusters", "", "JSON object of host or /path-prefix to {\"cluster-name\":\"...\",\"clusteruuid\":\"...\",\"es-version\":\"...\"} clusters served besides the default one, which can also override health-status, delay, dup, toomany, nonindex, toolarge and bulk-429, @path reads it from a file, empty string is a single cluster")
+	flag.StringVar(&buildHash, "build-hash", "", "build_hash returned by /, empty string is a hash derived from the version")
+	flag.StringVar(&buildDate, "build-date", "", "build_date returned by /, empty string is 2024-01-01T00:00:00.000000000Z")
+	flag.StringVar(&luceneVersion, "lucene-version", "", "lucene_version returned by /, empty string is the Lucene version of the major version of es-version")
+	flag.StringVar(&healthStatus, "health-status", "green", "cluster health status: green, yellow or red")
+	flag.StringVar(&licenseType, "license-type", "trial", "license type returned by /_license: basic, standard, gold, platinum, enterprise or trial")
+	flag.StringVar(&licenseStatus, "license-status", "active", "license status returned by /_license: active, expired or invalid")
+	flag.StringVar(&licenseExpiry, "license-expiry", "24h", "license expiry returned by /_license, an RFC 3339 date or a Go 'time.Duration' from now, negative durations and past dates are expired")
+	flag.DurationVar(&licenseExpiresIn, "license-expires-in", 0, "Go 'time.Duration' after which the license expires, /_license then returns an expired status and an expiry in the past, 0 is never, overrides license-expiry")
 	flag.DurationVar(&metricsInterval, "metrics", 0, "Go 'time.Duration' to wait between printing metrics to stdout, 0 is no metrics")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector URL, eg http://localhost:4318, metrics are pushed to instead of printed to stdout, every metrics duration or 10s, empty string is no OTLP export")
+	flag.StringVar(&otlpTraces, "otlp-traces-endpoint", "", "OTLP/HTTP collector URL, eg http://localhost:4318, a span of every request is pushed to, continuing the trace of a traceparent header, empty string is no tracing")
+	flag.StringVar(&indexMetrics, "index-metrics", "", "comma separated indices, which can contain * wildcards, whose bulk actions are counted per index in bulk.items.index.{index}.total metrics, empty string is no per index metrics")
+	flag.IntVar(&maxUserAgents, "max-user-agents", api.DefaultMaxUserAgents, "number of distinct user agents in the metrics and /_mock/useragents, later ones are counted as other")
+	flag.StringVar(&serviceVersion, "service-version", "", "service.version resource attribute of the OTLP metrics and spans, empty string is no service.version")
+	flag.Var(resourceAttrs, "resource-attribute", "key=value resource attribute added to the OTLP metrics and spans, can be repeated")
 	flag.StringVar(&certFile, "certfile", "", "path to PEM certificate file, empty sting is no TLS")
 	flag.StringVar(&keyFile, "keyfile", "", "path to PEM private key file, empty sting is no TLS")
+	flag.BoolVar(&useHTTP2, "http2", false, "serve HTTP/2, using h2c when TLS is not enabled")
+	flag.StringVar(&clientCAFile, "client-ca", "", "path to PEM CA certificate file used to verify client certificates, empty string is no client certificates")
+	flag.StringVar(&clientAuth, "client-auth", "require-and-verify", "client certificate policy when client-ca is set: none, request, require, verify-if-given or require-and-verify")
+	flag.BoolVar(&verbose, "verbose", false, "log every request with its body and response status to stderr")
+	flag.StringVar(&logFormat, "log-format", "text", "format of the verbose request logs: text or json")
+	flag.IntVar(&logBodyLimit, "log-body-limit", -1, "maximum number of request body bytes in the verbose request logs, 0 is no bodies and negative is no limit")
+	flag.StringVar(&productHeader, "product-header", "Elasticsearch", "X-Elastic-Product header value of every response, empty string is no header")
+	flag.Var(headers, "header", "name=value header added to every response, can be repeated")
+	flag.Var(&warnings, "warning", "deprecation warning sent in a Warning header of every response, can be repeated")
+	flag.IntVar(&defaultStatus, "default-status", http.StatusOK, "status of requests to unknown paths, between 200 and 599")
+	flag.StringVar(&defaultBody, "default-body", "", "body of requests to unknown paths, @path reads it from a file, empty string is the tagline or an Elasticsearch error depending on default-status")
+	flag.StringVar(&pathFaultsJSON, "path-faults", "", "JSON object of path to {\"status\":502,\"percent\":100,\"body\":\"...\"} errors returned before requests are handled, @path reads it from a file, empty string is no path faults")
 	flag.DurationVar(&delay, "delay", 0, "Go 'time.Duration' to wait before processing API request, 0 is no delay")
+	flag.Float64Var(&delayJitter, "delay-jitter", 0, "fraction between 0 and 1 each delay is randomized by, eg 0.2 is between 80% and 120% of delay, 0 is no jitter")
+	flag.Int64Var(&healthyFor, "healthy-for", 0, "number of bulk requests served before the dup, toomany, nonindex, toolarge, bulk-429 and reject-shard faults start, 0 is from the start")
+	flag.Int64Var(&failAfter, "fail-after", 0, "number of bulk requests served before every bulk request fails with StatusServiceUnavailable, 0 is never")
+	flag.UintVar(&percentHang, "hang", 0, "percent chance a request never gets a response until the client gives up")
+	flag.UintVar(&percentReset, "reset", 0, "percent chance the connection of a request is closed without a response")
+	flag.DurationVar(&drip, "drip", 0, "Go 'time.Duration' to wait between each 16 bytes of response bodies, 0 is no dripping")
+	flag.DurationVar(&coldStart, "cold-start", 0, "Go 'time.Duration' extra delay of the first cold-start-requests requests, 0 is no cold start")
+	flag.Int64Var(&coldStartCount, "cold-start-requests", 1, "number of requests delayed by cold-start")
+	flag.DurationVar(&h2Scramble, "h2-scramble", 0, "Go 'time.Duration' maximum random delay added to HTTP/2 requests so responses arrive out of order, 0 is no scrambling")
+	flag.Int64Var(&maxContentLength, "max-content-length", 0, "maximum decompressed bulk request body size in bytes, larger bodies return StatusRequestEntityTooLarge, 0 is no limit")
+	flag.IntVar(&maxLineSize, "max-line-size", api.DefaultMaxLineSize, "maximum size in bytes of a line in a bulk request body")
+	flag.BoolVar(&streamBulk, "stream-bulk", false, "write and flush bulk responses item by item instead of all at once")
+	flag.BoolVar(&noAutoCreate, "no-auto-create", false, "bulk actions into indices that were not created with PUT /{index} return StatusNotFound, like action.auto_create_index false")
+	flag.BoolVar(&strictBulk, "strict-bulk", false, "return StatusBadRequest for malformed bulk request bodies instead of skipping malformed lines")
+	flag.DurationVar(&refreshInterval, "refresh-interval", 0, "Go 'time.Duration' between index refreshes, bulk and _doc requests with refresh=wait_for wait for the next one, 0 is no wait")
+	flag.DurationVar(&reindexDuration, "reindex-duration", 0, "Go 'time.Duration' a _reindex runs before it completes, listed by /_tasks, 0 completes at once")
+	flag.BoolVar(&inferMappings, "infer-mappings", false, "infer the mapping of each index from the first document indexed into it, returned by /{index}/_mapping with the mappings of its template")
+	flag.BoolVar(&store, "store", false, "keep the documents sent with bulk requests in memory, implied by heap-watermark")
+	flag.Int64Var(&heapWatermark, "heap-watermark", 0, "simulated heap bytes above which requests return StatusTooManyRequests with a circuit_breaking_exception, 0 is no circuit breaker")
+	flag.StringVar(&deniedPrivileges, "deny-privileges", "", "comma separated privileges, which can contain * wildcards, reported as not granted by /_security/user/_has_privileges, empty string grants every privilege")
+	flag.StringVar(&upstream, "upstream", "", "URL of a real Elasticsearch the requests are forwarded to after the delays and faults are injected, empty string is no upstream")
+	flag.StringVar(&replayFile, "replay", "", "capture file of recorded responses served to matching requests instead of the mock responses, empty string is no replay")
+	flag.StringVar(&historyFile, "history-file", "", "file the requests of the /_history endpoint are appended to as JSON lines instead of being kept in memory, empty string is no file")
+	flag.Int64Var(&historyMaxSize, "history-file-max-size", 0, "size in bytes above which the history file is rotated to history-file.1, 0 is no rotation")
+	flag.IntVar(&historyCap, "history", 0, "number of requests kept in the /_history endpoint, 0 is no history")
+	flag.DurationVar(&retryAfter, "retry-after", 0, "Go 'time.Duration' sent as Retry-After header with StatusTooManyRequests and StatusServiceUnavailable responses, 0 is no header")
+	flag.Float64Var(&rps, "rps", 0, "requests per second above which requests return StatusTooManyRequests, 0 is no limit")
+	flag.IntVar(&shards, "shards", 1, "number of primary shards create actions are routed to by routing or _id")
+	flag.IntVar(&replicas, "replicas", 0, "number of replicas of each primary shard, counted in the _shards of the responses")
+	flag.UintVar(&percentShardFail, "shard-failures", 0, "percent chance a search, _refresh, _flush or _forcemerge response, or with replicas at least 1 a _doc write response, reports a failed shard")
+	flag.IntVar(&rejectShard, "reject-shard", -1, "shard number whose create actions return StatusTooManyRequests, -1 is no shard rejection")
+	flag.StringVar(&failingPipeline, "failing-pipeline", "", "ingest pipeline whose index and create actions fail with a fail_processor_exception, empty string is no failing pipeline")
 
 	uid = uuid.New()
-	expire = time.Now().Add(24 * time.Hour)
 	flag.Parse()
 	if (percentDuplicate + percentTooMany + percentNonIndex) > 100 {
 		log.Fatalf("Total of create action percentages must not be more than 100.\nd: %d, t:%d, n:%d", percentDuplicate, percentTooMany, percentNonIndex)
 	}
-	if percentTooLarge > 100 {
-		log.Fatalf("percentage StatusEntityTooLarge must be less than 100")
+	if percentTooLarge+percentBulk429 > 100 {
+		log.Fatalf("Total of toolarge and bulk-429 percentages must not be more than 100")
+	}
+	switch licenseType {
+	case "basic", "standard", "gold", "platinum", "enterprise", "trial":
+	default:
+		log.Fatalf("unknown license-type %q", licenseType)
+	}
+	switch licenseStatus {
+	case "active", "expired", "invalid":
+	default:
+		log.Fatalf("unknown license-status %q", licenseStatus)
+	}
+	var err error
+	if expire, err = parseExpiry(licenseExpiry); err != nil {
+		log.Fatalf("license-expiry: %s", err)
+	}
+	if licenseExpiresIn < 0 {
+		log.Fatalf("license-expires-in must not be negative")
+	}
+	if refreshInterval < 0 {
+		log.Fatalf("refresh-interval must not be negative")
+	}
+	if reindexDuration < 0 {
+		log.Fatalf("reindex-duration must not be negative")
+	}
+	switch healthStatus {
+	case "green", "yellow", "red":
+	default:
+		log.Fatalf("unknown health-status %q", healthStatus)
+	}
+	if logFormat != "text" && logFormat != "json" {
+		log.Fatalf("unknown log-format %q", logFormat)
+	}
+	if _, ok := clientAuthTypes[clientAuth]; !ok {
+		log.Fatalf("unknown client-auth %q", clientAuth)
+	}
+	if clientCAFile != "" && (certFile == "" || keyFile == "") {
+		log.Fatalf("client-ca requires certfile and keyfile")
+	}
+	if healthyFor < 0 || failAfter < 0 {
+		log.Fatalf("healthy-for and fail-after must not be negative")
+	}
+	if otlpEndpoint != "" {
+		if !strings.HasPrefix(otlpEndpoint, "http://") && !strings.HasPrefix(otlpEndpoint, "https://") {
+			log.Fatalf("otlp-endpoint must be an http or https URL")
+		}
+		if metricsInterval <= 0 {
+			metricsInterval = 10 * time.Second
+		}
+	}
+	if otlpTraces != "" && !strings.HasPrefix(otlpTraces, "http://") && !strings.HasPrefix(otlpTraces, "https://") {
+		log.Fatalf("otlp-traces-endpoint must be an http or https URL")
+	}
+	if maxUserAgents < 1 {
+		log.Fatalf("max-user-agents must be at least 1")
+	}
+	if percentHang+percentReset > 100 {
+		log.Fatalf("Total of hang and reset percentages must not be more than 100")
+	}
+	// a 1xx status is informational, the body would follow with a 200
+	if defaultStatus < 200 || defaultStatus > 599 {
+		log.Fatalf("default-status must be between 200 and 599")
+	}
+	if pathFaultsJSON != "" {
+		if path, ok := strings.CutPrefix(pathFaultsJSON, "@"); ok {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				log.Fatalf("error reading path-faults file: %s", err)
+			}
+			pathFaultsJSON = string(b)
+		}
+		if err := json.Unmarshal([]byte(pathFaultsJSON), &pathFaults); err != nil {
+			log.Fatalf("error parsing path-faults: %s", err)
+		}
+		for p, pf := range pathFaults {
+			if pf.Status < 100 || pf.Status > 999 {
+				log.Fatalf("path-faults status of %s must be a valid HTTP status", p)
+			}
+			if pf.Percent != nil && *pf.Percent > 100 {
+				log.Fatalf("path-faults percent of %s must not be more than 100", p)
+			}
+		}
+	}
+	defaultCluster = clusterConfig{
+		ClusterUUID:  clusterUUID,
+		ClusterName:  clusterName,
+		Version:      esVersion,
+		HealthStatus: healthStatus,
+		Delay:        jsonDuration(delay),
+		Duplicate:    percentDuplicate,
+		TooMany:      percentTooMany,
+		NonIndex:     percentNonIndex,
+		TooLarge:     percentTooLarge,
+		Bulk429:      percentBulk429,
+	}
+	if clustersJSON != "" {
+		if path, ok := strings.CutPrefix(clustersJSON, "@"); ok {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				log.Fatalf("error reading clusters file: %s", err)
+			}
+			clustersJSON = string(b)
+		}
+		if clusters, err = parseClusters(clustersJSON, defaultCluster); err != nil {
+			log.Fatalf("error parsing clusters: %s", err)
+		}
+	}
+	if path, ok := strings.CutPrefix(defaultBody, "@"); ok {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("error reading default-body file: %s", err)
+		}
+		defaultBody = string(body)
+	}
+	if delayJitter < 0 || delayJitter > 1 {
+		log.Fatalf("delay-jitter must be between 0 and 1")
+	}
+	if shards < 1 {
+		log.Fatalf("number of shards must be at least 1")
+	}
+	if replicas < 0 {
+		log.Fatalf("number of replicas must not be negative")
+	}
+	if percentShardFail > 100 {
+		log.Fatalf("shard-failures must not be larger than 100")
+	}
+	if rejectShard >= shards {
+		log.Fatalf("reject-shard must be less than the number of shards (%d)", shards)
 	}
 }
 
 func main() {
 	mux := http.NewServeMux()
 
-	if metricsInterval > 0 {
+	resource := map[string]string{
+		"service.name":        "mock-es",
+		"service.instance.id": uid.String(),
+	}
+	if serviceVersion != "" {
+		resource["service.version"] = serviceVersion
+	}
+	for k, v := range resourceAttrs {
+		resource[k] = v
+	}
+	var exporter *otlpExporter
+	switch {
+	case otlpEndpoint != "":
+		exporter = newOTLPExporter(otlpEndpoint, metrics.DefaultRegistry, resource)
+		go exporter.run(metricsInterval)
+	case metricsInterval > 0:
 		go metrics.WriteJSON(metrics.DefaultRegistry, metricsInterval, os.Stdout)
 	}
+	dump := make(chan os.Signal, 1)
+	notifyDump(dump)
+	go func() {
+		for range dump {
+			metrics.WriteJSONOnce(metrics.DefaultRegistry, os.Stdout)
+		}
+	}()
+
+	h := newHandler(defaultCluster, uid, metrics.DefaultRegistry)
+	if historyFile != "" {
+		rh := openHistoryFile(historyFile)
+		defer rh.Close()
+		h.RequestHistory = rh
+	}
+	handlers := []*api.APIHandler{h}
+	var root http.Handler = h
+	if len(clusters) > 0 {
+		vc := api.NewVirtualClusters(h)
+		for key, c := range clusters {
+			// the metrics of each cluster are prefixed with its key, as
+			// clusters can share a name
+			name := clusterKeyName(key)
+			ch := newHandler(c, uuid.New(), metrics.NewPrefixedChildRegistry(metrics.DefaultRegistry, "cluster."+name+"."))
+			if historyFile != "" {
+				ext := filepath.Ext(historyFile)
+				rh := openHistoryFile(strings.TrimSuffix(historyFile, ext) + "." + name + ext)
+				defer rh.Close()
+				ch.RequestHistory = rh
+			}
+			handlers = append(handlers, ch)
+			vc.Handle(key, ch)
+		}
+		root = vc
+	}
+	mux.Handle("/", root)
+
+	var handler http.Handler = mux
+	if verbose {
+		handler = loggingMiddleware(mux, logFormat, logBodyLimit)
+	}
+	var tracer *otlpTracer
+	if otlpTraces != "" {
+		tracer = newOTLPTracer(otlpTraces, resource)
+		go tracer.run(spanExportInterval)
+		handler = tracer.middleware(handler)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: handler}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			log.Fatalf("error reading client CA file: %s", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			log.Fatalf("error no certificates found in client CA file %s", clientCAFile)
+		}
+		srv.TLSConfig = &tls.Config{ClientCAs: clientCAs, ClientAuth: clientAuthTypes[clientAuth]}
+	}
+	if useHTTP2 {
+		h2s := &http2.Server{}
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			log.Fatalf("error configuring HTTP/2: %s", err)
+		}
+		if certFile == "" || keyFile == "" {
+			srv.Handler = h2c.NewHandler(handler, h2s)
+		}
+	}
 
-	mux.Handle("/", api.NewAPIHandler(uid, clusterUUID, metrics.DefaultRegistry, expire, delay, percentDuplicate, percentTooMany, percentNonIndex, percentTooLarge))
+	for _, h := range handlers {
+		srv.RegisterOnShutdown(h.StopHanging)
+	}
+	shutdownDone := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		for _, h := range handlers {
+			h.SetReady(false)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("error shutting down server: %s", err)
+		}
+		close(shutdownDone)
+	}()
 
+	ln, err := listen(addr)
+	if err != nil {
+		log.Fatalf("error listening on %s: %s", addr, err)
+	}
+	log.Printf("listening on %s", ln.Addr())
+	if portFile != "" {
+		if err := writePortFile(portFile, ln.Addr().String()); err != nil {
+			log.Fatalf("error writing port file: %s", err)
+		}
+	}
+	for _, h := range handlers {
+		h.SetReady(true)
+	}
 	switch {
 	case certFile != "" && keyFile != "":
-		if err := http.ListenAndServeTLS(addr, certFile, keyFile, mux); err != nil {
+		if err := srv.ServeTLS(ln, certFile, keyFile); err != nil {
 			if err != http.ErrServerClosed {
 				log.Fatalf("error running HTTPs server: %s", err)
 			}
 		}
 	default:
-		if err := http.ListenAndServe(addr, mux); err != nil {
+		if err := srv.Serve(ln); err != nil {
 			if err != http.ErrServerClosed {
 				log.Fatalf("error running HTTP server: %s", err)
 			}
 		}
 	}
+
+	<-shutdownDone
+	if tracer != nil {
+		if err := tracer.export(); err != nil {
+			log.Printf("error exporting spans: %s", err)
+		}
+	}
+	switch {
+	case exporter != nil:
+		if err := exporter.export(); err != nil {
+			log.Printf("error exporting metrics: %s", err)
+		}
+	case metricsInterval > 0:
+		metrics.WriteJSONOnce(metrics.DefaultRegistry, os.Stdout)
+	}
+}
+
+// openHistoryFile returns the file history at path, rotated at
+// history-file-max-size
+func openHistoryFile(path string) *api.RequestHistory {
+	rh, err := api.NewFileRequestHistory(path, historyMaxSize)
+	if err != nil {
+		log.Fatalf("error opening history-file: %s", err)
+	}
+	return rh
+}
+
+// newHandler returns an APIHandler configured by the flags, with the
+// identity and faults of c, and its metrics in registry
+func newHandler(c clusterConfig, uid uuid.UUID, registry metrics.Registry) *api.APIHandler {
+	h := api.NewAPIHandlerWithOptions(
+		api.WithUUID(uid),
+		api.WithClusterUUID(c.ClusterUUID),
+		api.WithMetricsRegistry(registry),
+		api.WithExpire(expire),
+		api.WithDelay(time.Duration(c.Delay)),
+		api.WithDuplicatePercent(c.Duplicate),
+		api.WithTooManyPercent(c.TooMany),
+		api.WithNonIndexPercent(c.NonIndex),
+		api.WithTooLargePercent(c.TooLarge),
+		api.WithBulk429Percent(c.Bulk429),
+	)
+	h.ClusterName = c.ClusterName
+	h.Version = c.Version
+	h.BuildHash = buildHash
+	h.BuildDate = buildDate
+	h.LuceneVersion = luceneVersion
+	h.HealthStatus = c.HealthStatus
+	h.LicenseType = licenseType
+	h.LicenseStatus = licenseStatus
+	if licenseExpiresIn > 0 {
+		h.Expire = time.Now().Add(licenseExpiresIn)
+		time.AfterFunc(licenseExpiresIn, h.ExpireLicense)
+	}
+	h.Shards = shards
+	h.RejectShard = rejectShard
+	h.Replicas = replicas
+	h.ShardFailurePercent = percentShardFail
+	h.FailingPipeline = failingPipeline
+	h.H2Scramble = h2Scramble
+	h.DelayJitter = delayJitter
+	h.ColdStart = coldStart
+	h.Drip = drip
+	h.DefaultStatus = defaultStatus
+	h.DefaultBody = defaultBody
+	h.PublishAddress = publishAddress
+	h.PathFaults = pathFaults
+	h.IndexMetrics = indexMetrics
+	h.DeniedPrivileges = deniedPrivileges
+	h.UserAgentTracker.MaxAgents = maxUserAgents
+	h.HealthyFor = healthyFor
+	h.FailAfter = failAfter
+	if sniffNodes != "" {
+		h.SniffNodes = strings.Split(sniffNodes, ",")
+	}
+	h.HangPercent = percentHang
+	h.ResetPercent = percentReset
+	h.ColdStartRequests = coldStartCount
+	h.StrictBulk = strictBulk
+	h.InferMappings = inferMappings
+	h.RefreshInterval = refreshInterval
+	h.ReindexDuration = reindexDuration
+	h.NoAutoCreate = noAutoCreate
+	h.MaxContentLength = maxContentLength
+	h.StreamBulk = streamBulk
+	h.MaxLineSize = maxLineSize
+	h.RetryAfter = retryAfter
+	h.ProductHeader = productHeader
+	h.Headers = http.Header(headers)
+	h.Warnings = warnings
+	if rps > 0 {
+		h.RateLimiter = rate.NewLimiter(rate.Limit(rps), int(math.Max(1, math.Ceil(rps))))
+	}
+	if upstream != "" {
+		u, err := url.Parse(upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("upstream must be an http or https URL")
+		}
+		h.Upstream = api.NewUpstreamProxy(u)
+	}
+	if replayFile != "" {
+		replay, err := api.LoadReplay(replayFile)
+		if err != nil {
+			log.Fatalf("error loading replay file: %s", err)
+		}
+		h.Replay = replay
+	}
+	if historyCap > 0 {
+		h.RequestHistory = api.NewRequestHistory(historyCap)
+	}
+	if heapWatermark > 0 {
+		h.HeapWatermark = heapWatermark
+	}
+	if store || heapWatermark > 0 {
+		h.Store = api.NewDocumentStore()
+	}
+	return h
+}
+
+// listen returns a listener on addr, which is either a TCP ip:port or
+// unix:path for a Unix domain socket.  A stale socket file left by a
+// previous run is removed, the listener removes the socket file when it is
+// closed.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket failed: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// writePortFile writes addr to path.  The file is renamed into place so
+// a harness polling for it never reads a partial address.
+func writePortFile(path, addr string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(addr+"\n"), 0o644); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename failed: %w", err)
+	}
+	return nil
+}
+
+// parseExpiry returns the license expiry of s, which is either an RFC 3339
+// date, eg 2024-01-31T00:00:00Z, or a duration from now, eg -1h
+func parseExpiry(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 date nor a duration", s)
+	}
+	return time.Now().Add(d), nil
 }
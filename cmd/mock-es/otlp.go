@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// otlpExporter pushes the counters and gauges of a metrics registry to an
+// OpenTelemetry collector, with the OTLP/HTTP protocol and JSON encoding,
+// so no OpenTelemetry SDK is needed.  OTLP over gRPC is not supported.
+// Counters and meters are cumulative sums, histograms and timers summaries.
+// The resource attributes identify the instance the metrics come from.
+type otlpExporter struct {
+	url      string
+	registry metrics.Registry
+	resource map[string]string
+	client   *http.Client
+	start    time.Time
+}
+
+// newOTLPExporter returns an exporter to the collector at endpoint, eg
+// http://localhost:4318.  The /v1/metrics path is added unless endpoint
+// already has it.
+func newOTLPExporter(endpoint string, registry metrics.Registry, resource map[string]string) *otlpExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/metrics") {
+		url += "/v1/metrics"
+	}
+	return &otlpExporter{url: url, registry: registry, resource: resource, client: &http.Client{Timeout: 10 * time.Second}, start: time.Now()}
+}
+
+// run exports the metrics every interval, logging failed exports
+func (e *otlpExporter) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := e.export(); err != nil {
+			log.Printf("error exporting metrics: %s", err)
+		}
+	}
+}
+
+// export pushes the current value of the metrics to the collector
+func (e *otlpExporter) export() error {
+	return postOTLP(e.client, e.url, e.payload(time.Now()))
+}
+
+// postOTLP posts payload in the OTLP JSON encoding to the collector at url
+func postOTLP(client *http.Client, url string, payload map[string]any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// payload returns an ExportMetricsServiceRequest in the OTLP JSON encoding
+func (e *otlpExporter) payload(now time.Time) map[string]any {
+	start, ts := strconv.FormatInt(e.start.UnixNano(), 10), strconv.FormatInt(now.UnixNano(), 10)
+	var names []string
+	e.registry.Each(func(name string, _ any) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	otlpMetrics := []any{}
+	for _, name := range names {
+		switch m := e.registry.Get(name).(type) {
+		case metrics.Counter:
+			otlpMetrics = append(otlpMetrics, map[string]any{
+				"name": name,
+				"sum": map[string]any{
+					"dataPoints":             []any{map[string]any{"asInt": strconv.FormatInt(m.Count(), 10), "startTimeUnixNano": start, "timeUnixNano": ts}},
+					"aggregationTemporality": 2, // cumulative
+					"isMonotonic":            true,
+				},
+			})
+		case metrics.Gauge:
+			otlpMetrics = append(otlpMetrics, map[string]any{
+				"name": name,
+				"gauge": map[string]any{
+					"dataPoints": []any{map[string]any{"asInt": strconv.FormatInt(m.Value(), 10), "timeUnixNano": ts}},
+				},
+			})
+		case metrics.Meter:
+			otlpMetrics = append(otlpMetrics, map[string]any{
+				"name": name,
+				"sum": map[string]any{
+					"dataPoints":             []any{map[string]any{"asInt": strconv.FormatInt(m.Count(), 10), "startTimeUnixNano": start, "timeUnixNano": ts}},
+					"aggregationTemporality": 2, // cumulative
+					"isMonotonic":            true,
+				},
+			})
+		case metrics.Histogram:
+			s := m.Snapshot()
+			otlpMetrics = append(otlpMetrics, summaryMetric(name, "", s.Count(), float64(s.Sum()), s.Percentiles(summaryQuantiles), start, ts))
+		case metrics.Timer:
+			s := m.Snapshot()
+			otlpMetrics = append(otlpMetrics, summaryMetric(name, "ns", s.Count(), float64(s.Sum()), s.Percentiles(summaryQuantiles), start, ts))
+		}
+	}
+	return map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(e.resource)},
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]any{"name": "mock-es"},
+				"metrics": otlpMetrics,
+			}},
+		}},
+	}
+}
+
+// summaryQuantiles are the quantiles of the histograms and timers
+var summaryQuantiles = []float64{0, 0.5, 0.75, 0.95, 0.99, 1}
+
+// summaryMetric returns an OTLP JSON summary of the count and sum of the
+// samples of a histogram or timer, with the values of summaryQuantiles
+func summaryMetric(name, unit string, count int64, sum float64, values []float64, start, ts string) map[string]any {
+	quantiles := make([]any, len(summaryQuantiles))
+	for i, q := range summaryQuantiles {
+		quantiles[i] = map[string]any{"quantile": q, "value": values[i]}
+	}
+	m := map[string]any{
+		"name": name,
+		"summary": map[string]any{
+			"dataPoints": []any{map[string]any{
+				"count":             strconv.FormatInt(count, 10),
+				"sum":               sum,
+				"quantileValues":    quantiles,
+				"startTimeUnixNano": start,
+				"timeUnixNano":      ts,
+			}},
+		},
+	}
+	if unit != "" {
+		m["unit"] = unit
+	}
+	return m
+}
+
+// otlpAttributes returns the OTLP JSON key values of attributes, sorted by
+// key
+func otlpAttributes(attributes map[string]string) []any {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, stringAttribute(k, attributes[k]))
+	}
+	return kvs
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/mock-es/pkg/api"
+)
+
+const (
+	// spanExportInterval is the time between two exports of the spans
+	spanExportInterval = 5 * time.Second
+	// maxQueuedSpans is the number of spans kept between two exports, more
+	// spans are dropped
+	maxQueuedSpans = 4096
+)
+
+// span is the server span of a request, with hex encoded ids
+type span struct {
+	traceID      string
+	spanID       string
+	parentSpanID string
+	name         string
+	start        time.Time
+	end          time.Time
+	attributes   []any
+	failed       bool
+}
+
+// otlpTracer records a span for every request and pushes them to an
+// OpenTelemetry collector, with the OTLP/HTTP protocol and JSON encoding,
+// like otlpExporter does with the metrics.  A request with a W3C
+// traceparent header gets a span in the trace of its caller.
+type otlpTracer struct {
+	url      string
+	resource map[string]string
+	client   *http.Client
+
+	mu      sync.Mutex
+	spans   []span
+	dropped int
+}
+
+// newOTLPTracer returns a tracer exporting to the collector at endpoint, eg
+// http://localhost:4318.  The /v1/traces path is added unless endpoint
+// already has it.
+func newOTLPTracer(endpoint string, resource map[string]string) *otlpTracer {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &otlpTracer{url: url, resource: resource, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// middleware records a span for every request to next, with the method,
+// path and status of the request, and the number of actions of a bulk
+// request.  Requests the client gave up on are 499 errors, and requests
+// aborted with a panic, eg hung ones, are errors with the status written
+// before, if any.
+func (t *otlpTracer) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := span{name: r.Method, start: time.Now(), spanID: randomID(8)}
+		if traceID, parentSpanID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			s.traceID, s.parentSpanID = traceID, parentSpanID
+		} else {
+			s.traceID = randomID(16)
+		}
+		var items atomic.Int64
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		completed := false
+		// the span is recorded even when the handler panics to abort
+		defer func() {
+			s.end = time.Now()
+			status := lw.status
+			switch {
+			case status != 0:
+			case r.Context().Err() != nil:
+				// the client gave up before anything was written
+				status = 499
+			case completed:
+				status = http.StatusOK
+			}
+			s.failed = !completed || r.Context().Err() != nil || status >= http.StatusInternalServerError
+			s.attributes = []any{
+				stringAttribute("http.request.method", r.Method),
+				stringAttribute("url.path", r.URL.Path),
+			}
+			if status != 0 {
+				s.attributes = append(s.attributes, intAttribute("http.response.status_code", int64(status)))
+			}
+			if !completed {
+				s.attributes = append(s.attributes, stringAttribute("error.type", "aborted"))
+			}
+			if api.IsBulkPath(r.URL.Path) {
+				s.attributes = append(s.attributes, intAttribute("elasticsearch.bulk.items", items.Load()))
+			}
+			t.add(s)
+		}()
+		next.ServeHTTP(lw, r.WithContext(api.WithBulkItemsCounter(r.Context(), &items)))
+		completed = true
+	})
+}
+
+// add queues s for the next export, unless maxQueuedSpans are queued
+func (t *otlpTracer) add(s span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) >= maxQueuedSpans {
+		t.dropped++
+		return
+	}
+	t.spans = append(t.spans, s)
+}
+
+// run exports the queued spans every interval, logging failed exports
+func (t *otlpTracer) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := t.export(); err != nil {
+			log.Printf("error exporting spans: %s", err)
+		}
+	}
+}
+
+// export pushes the queued spans to the collector, the spans of a failed
+// export are lost
+func (t *otlpTracer) export() error {
+	t.mu.Lock()
+	spans, dropped := t.spans, t.dropped
+	t.spans, t.dropped = nil, 0
+	t.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("dropped %d spans, more than %d spans between exports", dropped, maxQueuedSpans)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+	return postOTLP(t.client, t.url, t.payload(spans))
+}
+
+// payload returns an ExportTraceServiceRequest of spans in the OTLP JSON
+// encoding
+func (t *otlpTracer) payload(spans []span) map[string]any {
+	otlpSpans := make([]any, 0, len(spans))
+	for _, s := range spans {
+		otlpSpan := map[string]any{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              2, // server
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        s.attributes,
+		}
+		if s.parentSpanID != "" {
+			otlpSpan["parentSpanId"] = s.parentSpanID
+		}
+		if s.failed {
+			otlpSpan["status"] = map[string]any{"code": 2} // error
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(t.resource)},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "mock-es"},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+// parseTraceparent returns the trace id and parent span id of a W3C
+// traceparent header, eg 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01,
+// and false if it is missing or invalid
+func parseTraceparent(header string) (traceID, parentSpanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", false
+	}
+	traceID, parentSpanID = parts[1], parts[2]
+	if !validID(traceID, 16) || !validID(parentSpanID, 8) || !validID(parts[3], 1) {
+		return "", "", false
+	}
+	return traceID, parentSpanID, true
+}
+
+// validID returns true if id is the lowercase hex encoding of n bytes that
+// are not all zero
+func validID(id string, n int) bool {
+	if len(id) != 2*n || id != strings.ToLower(id) {
+		return false
+	}
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	// the trace flags can be all zero
+	return n == 1
+}
+
+// randomID returns n random bytes hex encoded, eg a span id
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// stringAttribute returns an OTLP JSON key value with a string value
+func stringAttribute(key, value string) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"stringValue": value}}
+}
+
+// intAttribute returns an OTLP JSON key value with an int value, encoded as
+// a string like int64 values are in OTLP JSON
+func intAttribute(key string, value int64) map[string]any {
+	return map[string]any{"key": key, "value": map[string]any{"intValue": strconv.FormatInt(value, 10)}}
+}
@@ -1,9 +1,18 @@
 module github.com/elastic/mock-es
 
-go 1.21.9
+go 1.22
 
 require github.com/google/uuid v1.6.0
 
 require github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475
 
-require github.com/mileusna/useragent v1.3.4 // indirect
+require github.com/mileusna/useragent v1.3.4
+
+require golang.org/x/time v0.5.0
+
+require github.com/klauspost/compress v1.18.0
+
+require (
+	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0 // indirect
+)
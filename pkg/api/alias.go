@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// aliasIndex is an index an alias points to
+type aliasIndex struct {
+	Alias        string
+	Index        string
+	IsWriteIndex *bool
+}
+
+// aliasRegistry keeps the aliases and the indices they point to.  The zero
+// value is empty and ready to use.  It is safe for concurrent use.
+type aliasRegistry struct {
+	mu      sync.RWMutex
+	aliases map[string]map[string]*bool
+}
+
+// add points alias to index.  isWriteIndex is nil when it was not set.  An
+// alias has a single write index, so setting it unsets the previous one.
+func (ar *aliasRegistry) add(alias, index string, isWriteIndex *bool) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if ar.aliases == nil {
+		ar.aliases = make(map[string]map[string]*bool)
+	}
+	indices, ok := ar.aliases[alias]
+	if !ok {
+		indices = make(map[string]*bool)
+		ar.aliases[alias] = indices
+	}
+	if isWriteIndex != nil && *isWriteIndex {
+		for i, w := range indices {
+			if w != nil && *w {
+				indices[i] = new(bool)
+			}
+		}
+	}
+	indices[index] = isWriteIndex
+}
+
+// remove stops alias pointing to index, returning true if it did
+func (ar *aliasRegistry) remove(alias, index string) bool {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	if _, ok := ar.aliases[alias][index]; !ok {
+		return false
+	}
+	delete(ar.aliases[alias], index)
+	if len(ar.aliases[alias]) == 0 {
+		delete(ar.aliases, alias)
+	}
+	return true
+}
+
+// removeIndex stops all aliases pointing to index
+func (ar *aliasRegistry) removeIndex(index string) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for alias, indices := range ar.aliases {
+		delete(indices, index)
+		if len(indices) == 0 {
+			delete(ar.aliases, alias)
+		}
+	}
+}
+
+// writeIndex returns the index alias writes to, which is its index with
+// is_write_index set to true, or its only index, and the is_write_index of
+// that index.  It returns false if alias has no write index.
+func (ar *aliasRegistry) writeIndex(alias string) (string, *bool, bool) {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	indices := ar.aliases[alias]
+	for index, isWriteIndex := range indices {
+		if isWriteIndex != nil && *isWriteIndex {
+			return index, isWriteIndex, true
+		}
+	}
+	if len(indices) == 1 {
+		for index, isWriteIndex := range indices {
+			return index, isWriteIndex, true
+		}
+	}
+	return "", nil, false
+}
+
+// all returns the indices of all aliases sorted by alias and index
+func (ar *aliasRegistry) all() []aliasIndex {
+	ar.mu.RLock()
+	defer ar.mu.RUnlock()
+	var all []aliasIndex
+	for alias, indices := range ar.aliases {
+		for index, isWriteIndex := range indices {
+			all = append(all, aliasIndex{Alias: alias, Index: index, IsWriteIndex: isWriteIndex})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Alias != all[j].Alias {
+			return all[i].Alias < all[j].Alias
+		}
+		return all[i].Index < all[j].Index
+	})
+	return all
+}
+
+// aliasPath returns the index and alias of a /{index}/_alias/{name} or
+// /{index}/_aliases/{name} path, or false if p is not such a path
+func aliasPath(p string) (index, alias string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if len(parts) != 3 || (parts[1] != "_alias" && parts[1] != "_aliases") {
+		return "", "", false
+	}
+	if parts[0] == "" || strings.HasPrefix(parts[0], "_") || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
+
+// isAliasPath returns true for the /{index}/_alias/{name} endpoints
+func isAliasPath(p string) bool {
+	_, _, ok := aliasPath(p)
+	return ok
+}
+
+// Alias handles /{index}/_alias/{name} put requests by pointing the alias
+// to the index, and delete requests by removing the alias from the index.
+// The body of a put request can set is_write_index.
+func (h *APIHandler) Alias(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(aliasTotalMetrics, h.metricsRegistry)
+	h.stats.add("alias", 1)
+	index, alias, _ := aliasPath(r.URL.Path)
+	if r.Method == http.MethodDelete {
+		if !h.aliases.remove(alias, index) {
+			h.writeError(w, http.StatusNotFound, "aliases_not_found_exception", fmt.Sprintf("aliases [%s] missing", alias))
+			return
+		}
+		writeAcknowledged(w)
+		return
+	}
+
+	if !h.indexExists(index) {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", index))
+		return
+	}
+	var body struct {
+		IsWriteIndex *bool `json:"is_write_index"`
+	}
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("error reading alias body: %s", err)
+		return
+	}
+	if len(b) != 0 {
+		if err := json.Unmarshal(b, &body); err != nil {
+			h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+			return
+		}
+	}
+	h.aliases.add(alias, index, body.IsWriteIndex)
+	writeAcknowledged(w)
+	return
+}
@@ -1,60 +1,318 @@
 package api
 
 import (
-	"bufio"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/mileusna/useragent"
 	"github.com/rcrowley/go-metrics"
+	"golang.org/x/time/rate"
 )
 
 var (
-	rootTotalMetrics           string = "root.total"
-	licenseTotalMetrics        string = "license.total"
-	bulkCreateTotalMetrics     string = "bulk.create.total"
-	bulkCreateDuplicateMetrics string = "bulk.create.duplicate"
-	bulkCreateTooManyMetrics   string = "bulk.create.too_many"
-	bulkCreateNonIndexMetrics  string = "bulk.create.non_index"
-	bulkCreateOkMetrics        string = "bulk.create.ok"
-	bulkCreateTooLargeMetrics  string = "bulk.create.too_large"
-	bulkIndexTotalMetrics      string = "bulk.index.total"
-	bulkUpdateTotalMetrics     string = "bulk.update.total"
-	bulkDeleteTotalMetrics     string = "bulk.delete.total"
+	rootTotalMetrics               string = "root.total"
+	licenseTotalMetrics            string = "license.total"
+	bulkCreateTotalMetrics         string = "bulk.create.total"
+	bulkCreateDuplicateMetrics     string = "bulk.create.duplicate"
+	bulkCreateTooManyMetrics       string = "bulk.create.too_many"
+	bulkCreateNonIndexMetrics      string = "bulk.create.non_index"
+	bulkCreateOkMetrics            string = "bulk.create.ok"
+	bulkCreateTooLargeMetrics      string = "bulk.create.too_large"
+	bulkCreateShardRejectedMetrics string = "bulk.create.shard_rejected"
+	bulkIndexTotalMetrics          string = "bulk.index.total"
+	bulkUpdateTotalMetrics         string = "bulk.update.total"
+	bulkDeleteTotalMetrics         string = "bulk.delete.total"
+	bulkMalformedMetrics           string = "bulk.malformed"
+	circuitBreakerMetrics          string = "circuit_breaker.tripped"
+	rateLimitMetrics               string = "rate_limit.rejected"
+	clusterStatsTotalMetrics       string = "cluster.stats.total"
+	methodNotAllowedMetrics        string = "method_not_allowed"
+	clusterHealthTotalMetrics      string = "cluster.health.total"
+	catHealthTotalMetrics          string = "cat.health.total"
+	bulkPipelineFailedMetrics      string = "bulk.pipeline.failed"
+	ingestPipelineTotalMetrics     string = "ingest.pipeline.total"
+	indexTemplateTotalMetrics      string = "index_template.total"
+	dataStreamTotalMetrics         string = "data_stream.total"
+	legacyTemplateTotalMetrics     string = "legacy_template.total"
+	indexCreateMetrics             string = "index.create"
+	indexDeleteMetrics             string = "index.delete"
+	bulkIndexNotFoundMetrics       string = "bulk.index_not_found"
+	aliasTotalMetrics              string = "alias.total"
+	catAliasesTotalMetrics         string = "cat.aliases.total"
+	catTemplatesTotalMetrics       string = "cat.templates.total"
+	rolloverTotalMetrics           string = "rollover.total"
+	connectionHangMetrics          string = "connection.hang"
+	connectionResetMetrics         string = "connection.reset"
+	clientClosedMetrics            string = "client_closed"
+	defaultTotalMetrics            string = "default.total"
+	catNodesTotalMetrics           string = "cat.nodes.total"
+	nodesHTTPTotalMetrics          string = "nodes.http.total"
+	bulkRejectedMetrics            string = "bulk.rejected"
+	refreshTotalMetrics            string = "refresh.total"
+	flushTotalMetrics              string = "flush.total"
+	forceMergeTotalMetrics         string = "forcemerge.total"
+	countTotalMetrics              string = "count.total"
+	msearchTotalMetrics            string = "msearch.total"
+	catCountTotalMetrics           string = "cat.count.total"
+	pathFaultMetrics               string = "path_fault"
+	bulkFailedAfterMetrics         string = "bulk.failed_after"
+	bulkItemsTotalMetrics          string = "bulk.items.total"
+	replayTotalMetrics             string = "replay.total"
+	upstreamTotalMetrics           string = "upstream.total"
+	clusterSettingsTotalMetrics    string = "cluster.settings.total"
+	hasPrivilegesTotalMetrics      string = "has_privileges.total"
+	bulkBytesReceivedMetrics       string = "bulk.bytes.received"
+	bulkBytesDecodedMetrics        string = "bulk.bytes.decoded"
+	mappingTotalMetrics            string = "mapping.total"
+	docTotalMetrics                string = "doc.total"
+	docDeleteMetrics               string = "doc.delete"
+	docIndexMetrics                string = "doc.index"
+	refreshWaitForMetrics          string = "refresh.wait_for"
+	tasksTotalMetrics              string = "tasks.total"
+	reindexTotalMetrics            string = "reindex.total"
+	shardFailureMetrics            string = "shards.failed"
+	catShardsTotalMetrics          string = "cat.shards.total"
+	deprecationWarningMetrics      string = "deprecation.warnings"
 )
 
+// statusClientClosedRequest is recorded for requests whose client gave up
+// while they were delayed, like nginx does, as they get no response
+const statusClientClosedRequest = 499
+
+// inflightRequestHeap is the simulated heap in bytes used by each request
+// that is being handled
+const inflightRequestHeap = 1 << 20
+
 // BulkResponse is an Elastic Search Bulk Response, assuming
-// filter_path is "errors,items.*.error,items.*.status"
+// filter_path is "took,ingest_took,errors,items.*.error,items.*.status"
 type BulkResponse struct {
-	Errors bool             `json:"errors"`
-	Items  []map[string]any `json:"items,omitempty"`
+	Took       int64            `json:"took"`
+	IngestTook *int64           `json:"ingest_took,omitempty"`
+	Errors     bool             `json:"errors"`
+	Items      []map[string]any `json:"items,omitempty"`
+}
+
+// ErrorResponse is an Elasticsearch error response body
+type ErrorResponse struct {
+	Error  ErrorCause `json:"error"`
+	Status int        `json:"status"`
+}
+
+// ErrorCause is the type and reason of an Elasticsearch error
+type ErrorCause struct {
+	RootCause []ErrorCause `json:"root_cause,omitempty"`
+	Type      string       `json:"type"`
+	Reason    string       `json:"reason"`
 }
 
 // APIHandler struct.  Use NewAPIHandler to make sure it is filled in correctly for use.
 type APIHandler struct {
-	ActionOdds      [100]int
-	MethodOdds      [100]int
-	UUID            uuid.UUID
-	ClusterUUID     string
-	Expire          time.Time
-	Delay           time.Duration
-	metricsRegistry metrics.Registry
+	ActionOdds  [100]int
+	MethodOdds  [100]int
+	UUID        uuid.UUID
+	ClusterUUID string
+	ClusterName string
+	Version     string
+	// BuildHash, BuildDate and LuceneVersion are returned by / with
+	// Version, empty strings are defaults derived from Version.
+	BuildHash     string
+	BuildDate     string
+	LuceneVersion string
+	Expire        time.Time
+	// LicenseType and LicenseStatus are the type, eg trial, basic or
+	// platinum, and the status, eg active or expired, returned by /_license
+	LicenseType   string
+	LicenseStatus string
+	Delay         time.Duration
+	// DelayJitter randomizes each Delay within plus or minus this fraction
+	// of it, eg 0.2 sleeps between 80% and 120% of Delay.  0 is no jitter.
+	DelayJitter float64
+	// Drip trickles response bodies, writing them a few bytes at a time
+	// with this delay in between, so client read timeouts fire.  0 writes
+	// responses at once.
+	Drip time.Duration
+	// HangPercent is the percent chance a request never gets a response,
+	// until the client gives up or StopHanging is called
+	HangPercent uint
+	// ResetPercent is the percent chance the connection of a request is
+	// closed without a response
+	ResetPercent uint
+	// ColdStart is an extra delay for the first ColdStartRequests requests,
+	// simulating a cluster that is still warming up.  0 is no cold start.
+	ColdStart         time.Duration
+	ColdStartRequests int64
+	// Shards is the number of primary shards documents are routed to.
+	Shards int
+	// Replicas is the number of replicas of each primary shard, all of
+	// them active, counted in the _shards of the responses.
+	Replicas int
+	// ShardFailurePercent is the percent chance a search, broadcast action,
+	// eg _refresh, or document write reports a failed shard in its
+	// _shards, with a StatusOK response.
+	ShardFailurePercent uint
+	// RejectShard is the shard whose create actions are rejected as if
+	// its write queue were full, -1 disables shard rejection.
+	RejectShard int
+	// H2Scramble is the maximum random delay added to HTTP/2 requests so
+	// responses to concurrent streams are returned out of order.
+	H2Scramble time.Duration
+	// StrictBulk rejects malformed bulk bodies with StatusBadRequest
+	// instead of skipping the malformed lines.
+	StrictBulk bool
+	// Store keeps the documents sent with bulk requests, nil throws them away.
+	Store *DocumentStore
+	// HeapWatermark is the simulated heap in bytes above which requests
+	// fail with a circuit_breaking_exception, 0 disables the circuit breaker.
+	HeapWatermark int64
+	// RetryAfter is sent in the Retry-After header of StatusTooManyRequests
+	// and StatusServiceUnavailable responses, 0 omits the header.
+	RetryAfter time.Duration
+	// RateLimiter rejects requests above its rate with StatusTooManyRequests,
+	// nil is no rate limit.
+	RateLimiter *rate.Limiter
+	// UserAgentTracker counts the user agents seen by each endpoint.
+	UserAgentTracker *UserAgentTracker
+	// HealthStatus is the cluster health, green, yellow or red.
+	HealthStatus string
+	// ProductHeader is the X-Elastic-Product header value of every response,
+	// empty omits the header.
+	ProductHeader string
+	// ItemStatusFunc, if set, picks the status of each index, create and
+	// update action of a bulk request from the action, its metadata, eg
+	// _index, _id and routing, and its parsed document.  Returning 0 falls
+	// back to the default behavior.
+	ItemStatusFunc func(action string, meta, doc map[string]any) int
+	// MaxLineSize is the maximum size in bytes of a line in a bulk request
+	// body, 0 is DefaultMaxLineSize
+	MaxLineSize int
+	// StreamBulk makes bulk responses be written and flushed item by item,
+	// instead of being written at once when all actions are performed
+	StreamBulk bool
+	// MaxContentLength is the maximum size in bytes of a decompressed bulk
+	// request body, larger bodies return StatusRequestEntityTooLarge.  0 is
+	// no limit.
+	MaxContentLength int64
+	// NoAutoCreate makes bulk actions fail with index_not_found_exception
+	// unless their index was created with PUT /{index} or is a data stream
+	NoAutoCreate bool
+	// FailingPipeline is the ingest pipeline whose index and create actions
+	// fail, empty string is no failing pipeline
+	FailingPipeline string
+	// DefaultStatus is the status of requests to unknown paths, 0 is
+	// StatusOK.
+	DefaultStatus int
+	// DefaultBody is the body of requests to unknown paths.  When empty it
+	// is the tagline for a success DefaultStatus, and an Elasticsearch
+	// error otherwise.
+	DefaultBody string
+	// PublishAddress is the http address the node publishes to sniffing
+	// clients, empty is the Host the request was sent to.
+	PublishAddress string
+	// SniffNodes are the http addresses of the nodes returned to sniffing
+	// clients, each as a distinct node although this handler answers them
+	// all.  Empty is a single node at PublishAddress.
+	SniffNodes []string
+	// HealthyFor is the number of bulk requests served without the random
+	// faults of MethodOdds, ActionOdds and RejectShard, which only apply to
+	// later bulk requests.  0 applies them from the start.
+	HealthyFor int64
+	// FailAfter is the number of bulk requests served before every bulk
+	// request fails with StatusServiceUnavailable, as if the cluster fell
+	// over.  0 never fails them.
+	FailAfter int64
+	// IndexMetrics is a comma separated list of indices, which can contain
+	// * wildcards, whose bulk actions are also counted per index.  Empty
+	// string is no per index metrics.
+	IndexMetrics string
+	// Replay serves recorded responses to the requests it has them for,
+	// instead of the handlers.  nil is no replay.
+	Replay *Replay
+	// Upstream, if set, handles the requests instead of the mock handlers,
+	// eg an httputil.ReverseProxy to a real Elasticsearch.  Delays and
+	// faults are still injected first.
+	Upstream http.Handler
+	// InferMappings infers the mappings of each index from the first
+	// document indexed into it, /_mapping adds them to the template ones.
+	InferMappings bool
+	// RefreshInterval is the period of the index refreshes that bulk and
+	// _doc requests with refresh=wait_for wait for.  0 doesn't wait.
+	RefreshInterval time.Duration
+	// ReindexDuration is how long a _reindex runs, as a synthetic task,
+	// before it completes.  0 completes at once.
+	ReindexDuration time.Duration
+	// DeniedPrivileges is a comma separated list of privileges, which can
+	// contain * wildcards, that _has_privileges reports as not granted.
+	// Empty string grants every privilege.
+	DeniedPrivileges string
+	// PathFaults are errors returned for the requests to a path before
+	// they are handled, keyed by path or by a pattern with * wildcards.
+	PathFaults map[string]PathFault
+	// Headers are added to every response.
+	Headers http.Header
+	// Warnings are sent on every response as Warning headers, like the
+	// deprecation warnings of Elasticsearch.
+	Warnings []string
+	// RequestHistory records the requests handled, nil disables the history.
+	RequestHistory   *RequestHistory
+	inflight         atomic.Int64
+	ready            atomic.Bool
+	requests         atomic.Int64
+	bulkRequests     atomic.Int64
+	stats            endpointStats
+	clusterSettings  clusterSettings
+	inferred         inferredMappings
+	licenseExpiredAt atomic.Pointer[time.Time]
+	hangDone         chan struct{}
+	stopHanging      sync.Once
+	pipelines        namedStore
+	indexTemplates   namedStore
+	legacyTemplates  namedStore
+	dataStreams      dataStreams
+	indices          indexRegistry
+	aliases          aliasRegistry
+	tasks            taskRegistry
+	metricsRegistry  metrics.Registry
 }
 
-// NewAPIHandler return handler with Action and Method Odds array filled in
+// NewAPIHandler return handler with Action and Method Odds array filled in.
+// It is kept for compatibility, NewAPIHandlerWithOptions only needs the
+// settings that differ from the defaults.
 func NewAPIHandler(uuid uuid.UUID, clusterUUID string, metricsRegistry metrics.Registry, expire time.Time, delay time.Duration, percentDuplicate, percentTooMany, percentNonIndex, percentTooLarge uint) *APIHandler {
-	h := &APIHandler{UUID: uuid, Expire: expire, ClusterUUID: clusterUUID, Delay: delay, metricsRegistry: metricsRegistry}
+	return NewAPIHandlerWithOptions(
+		WithUUID(uuid),
+		WithClusterUUID(clusterUUID),
+		WithMetricsRegistry(metricsRegistry),
+		WithExpire(expire),
+		WithDelay(delay),
+		WithDuplicatePercent(percentDuplicate),
+		WithTooManyPercent(percentTooMany),
+		WithNonIndexPercent(percentNonIndex),
+		WithTooLargePercent(percentTooLarge),
+	)
+}
+
+// NewAPIHandlerWithOptions returns a handler configured by opts, with Action
+// and Method Odds array filled in
+func NewAPIHandlerWithOptions(opts ...Option) *APIHandler {
+	c := newHandlerConfig(opts)
+	percentDuplicate, percentTooMany, percentNonIndex, percentTooLarge, percentBulk429 := c.percentDuplicate, c.percentTooMany, c.percentNonIndex, c.percentTooLarge, c.percentBulk429
+	h := &APIHandler{UUID: c.uuid, Expire: c.expire, ClusterUUID: c.clusterUUID, Delay: c.delay, ColdStartRequests: 1, ClusterName: "mock", LicenseType: "trial", LicenseStatus: "active", HealthStatus: "green", Shards: 1, RejectShard: -1, ProductHeader: "Elasticsearch", UserAgentTracker: NewUserAgentTracker(), hangDone: make(chan struct{}), metricsRegistry: c.metricsRegistry}
 	if int((percentDuplicate + percentTooMany + percentNonIndex)) > len(h.ActionOdds) {
 		panic(fmt.Errorf("Total of percents can't be greater than %d", len(h.ActionOdds)))
 	}
-	if int(percentTooLarge) > len(h.MethodOdds) {
-		panic(fmt.Errorf("percent TooLarge cannot be greater than %d", len(h.MethodOdds)))
+	if int(percentTooLarge+percentBulk429) > len(h.MethodOdds) {
+		panic(fmt.Errorf("Total of TooLarge and Bulk429 percents can't be greater than %d", len(h.MethodOdds)))
 	}
 
 	// Fill in ActionOdds
@@ -81,143 +339,518 @@ func NewAPIHandler(uuid uuid.UUID, clusterUUID string, metricsRegistry metrics.R
 		h.MethodOdds[n] = http.StatusRequestEntityTooLarge
 		n++
 	}
+	for i := uint(0); i < percentBulk429; i++ {
+		h.MethodOdds[n] = http.StatusTooManyRequests
+		n++
+	}
 	for ; n < len(h.MethodOdds); n++ {
 		h.MethodOdds[n] = http.StatusOK
 	}
 
+	for _, f := range c.apply {
+		f(h)
+	}
 	return h
 }
 
 // ServeHTTP looks at the request and routes it to the correct handler function
 func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	time.Sleep(h.Delay)
-	ua := useragent.Parse(r.Header.Get("User-Agent"))
-	incrementCounter("user_agent."+ ua.String + ".total", h.metricsRegistry)
-	incrementCounter("user_agent."+ ua.String + "." + r.URL.Path, h.metricsRegistry)
+	// the probes are answered before any delay or fault, and not recorded
+	if isProbePath(r.URL.Path) {
+		if h.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			if r.URL.Path == "/_mock/healthz" {
+				h.Healthz(w, r)
+			} else {
+				h.Readyz(w, r)
+			}
+		}
+		return
+	}
+	h.inflight.Add(1)
+	defer h.inflight.Add(-1)
+	start := time.Now()
+	sr := &statusRecorder{ResponseWriter: w}
+	defer func() { h.recordRequest(r, start, sr.status) }()
+	w = sr
+	if h.Drip > 0 {
+		w = &dripWriter{ResponseWriter: w, delay: h.Drip, rc: http.NewResponseController(w)}
+	}
+	if filter, pretty := r.URL.Query().Get("filter_path"), isPretty(r); filter != "" || pretty {
+		fw := &formatWriter{ResponseWriter: w, pretty: pretty}
+		if filter != "" {
+			fp := parseFilterPath(filter)
+			fw.fp = &fp
+		}
+		defer fw.finish()
+		w = fw
+	}
+	if h.ProductHeader != "" {
+		w.Header().Set(http.CanonicalHeaderKey("X-Elastic-Product"), h.ProductHeader)
+	}
+	// the X-Opaque-Id of the client is echoed to correlate its requests,
+	// like Elasticsearch does
+	if opaqueID := r.Header.Get("X-Opaque-Id"); opaqueID != "" {
+		w.Header().Set(http.CanonicalHeaderKey("X-Opaque-Id"), opaqueID)
+	}
+	for name, values := range h.Headers {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	h.addWarnings(w, r)
+	delay := h.delay()
+	if h.ColdStart > 0 && h.requests.Add(1) <= h.ColdStartRequests {
+		delay += h.ColdStart
+	}
+	if r.ProtoMajor == 2 && h.H2Scramble > 0 {
+		delay += time.Duration(rand.Int63n(int64(h.H2Scramble)))
+	}
+	if !sleep(r, delay) {
+		incrementCounter(clientClosedMetrics, h.metricsRegistry)
+		sr.status = statusClientClosedRequest
+		return
+	}
+	if h.injectConnectionFault(w, r) {
+		return
+	}
+	agent := h.UserAgentTracker.normalize(r.UserAgent())
+	incrementCounter("user_agent."+agent+".total", h.metricsRegistry)
+	incrementCounter("user_agent."+agent+"."+r.URL.Path, h.metricsRegistry)
+	if h.RateLimiter != nil && !h.RateLimiter.Allow() {
+		incrementCounter(rateLimitMetrics, h.metricsRegistry)
+		h.writeError(w, http.StatusTooManyRequests, "es_rejected_execution_exception", fmt.Sprintf("rejected execution of coordinating operation, limit of [%g] requests per second exceeded", float64(h.RateLimiter.Limit())))
+		return
+	}
+	if heap := h.HeapUsage(); h.HeapWatermark > 0 && heap > h.HeapWatermark {
+		incrementCounter(circuitBreakerMetrics, h.metricsRegistry)
+		h.writeError(w, http.StatusTooManyRequests, "circuit_breaking_exception", fmt.Sprintf("[parent] Data too large, data for [<http_request>] would be [%db], which is larger than the limit of [%db]", heap, h.HeapWatermark))
+		return
+	}
+	if h.injectPathFault(w, r) {
+		return
+	}
+	if h.Replay != nil && h.Replay.serve(w, r) {
+		incrementCounter(replayTotalMetrics, h.metricsRegistry)
+		return
+	}
+	if h.Upstream != nil && !isMockPath(r.URL.Path) {
+		h.proxy(w, r)
+		return
+	}
 	switch {
-	case r.Method == http.MethodGet && r.URL.Path == "/":
-		h.Root(w, r)
+	case r.URL.Path == "/":
+		if h.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			h.Root(w, r)
+		}
 		return
-	case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
-		h.Bulk(w, r)
+	case IsBulkPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodPost, http.MethodPut) {
+			h.Bulk(w, r)
+		}
 		return
-	case r.Method == http.MethodGet && r.URL.Path == "/_license":
-		h.License(w, r)
+	case r.URL.Path == "/_license":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.License(w, r)
+		}
+		return
+	case r.URL.Path == "/_cluster/stats":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.ClusterStats(w, r)
+		}
+		return
+	case r.URL.Path == "/_cluster/settings":
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPut) {
+			h.ClusterSettings(w, r)
+		}
+		return
+	case r.URL.Path == "/_cluster/health":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.ClusterHealth(w, r)
+		}
+		return
+	case r.URL.Path == "/_cat/health":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.CatHealth(w, r)
+		}
+		return
+	case r.URL.Path == ingestPipelinePath:
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.IngestPipeline(w, r)
+		}
+		return
+	case isNamedPath(r.URL.Path, ingestPipelinePath):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPut) {
+			h.IngestPipeline(w, r)
+		}
+		return
+	case r.URL.Path == indexTemplatePath:
+		if h.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			h.IndexTemplate(w, r)
+		}
+		return
+	case isNamedPath(r.URL.Path, indexTemplatePath):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete) {
+			h.IndexTemplate(w, r)
+		}
+		return
+	case r.URL.Path == legacyTemplatePath:
+		if h.allowMethods(w, r, http.MethodGet, http.MethodHead) {
+			h.LegacyTemplate(w, r)
+		}
+		return
+	case isNamedPath(r.URL.Path, legacyTemplatePath):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete) {
+			h.LegacyTemplate(w, r)
+		}
+		return
+	case r.URL.Path == dataStreamPath:
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.DataStream(w, r)
+		}
+		return
+	case isNamedPath(r.URL.Path, dataStreamPath):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPut) {
+			h.DataStream(w, r)
+		}
+		return
+	case isNodesHTTPPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.NodesHTTP(w, r)
+		}
+		return
+	case isHasPrivilegesPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+			h.HasPrivileges(w, r)
+		}
+		return
+	case r.URL.Path == "/_cat/nodes":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.CatNodes(w, r)
+		}
+		return
+	case isCatCountPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.CatCount(w, r)
+		}
+		return
+	case r.URL.Path == "/_cat/aliases":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.CatAliases(w, r)
+		}
+		return
+	case r.URL.Path == "/_cat/shards", isNamedPath(r.URL.Path, "/_cat/shards"):
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.CatShards(w, r)
+		}
+		return
+	case r.URL.Path == "/_cat/templates":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.CatTemplates(w, r)
+		}
+		return
+	case r.URL.Path == "/_mock/useragents":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.UserAgents(w, r)
+		}
+		return
+	case r.URL.Path == "/_mock/stats":
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.MockStats(w, r)
+		}
+		return
+	case r.URL.Path == tasksPath, isNamedPath(r.URL.Path, tasksPath):
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.Tasks(w, r)
+		}
+		return
+	case r.URL.Path == "/_reindex":
+		if h.allowMethods(w, r, http.MethodPost) {
+			h.Reindex(w, r)
+		}
+		return
+	case r.URL.Path == "/_mock/task":
+		if h.allowMethods(w, r, http.MethodPost) {
+			h.MockTask(w, r)
+		}
+		return
+	case r.URL.Path == "/_mock/expire-license":
+		if h.allowMethods(w, r, http.MethodPost) {
+			h.MockExpireLicense(w, r)
+		}
+		return
+	case isDocPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPost, http.MethodDelete) {
+			h.Doc(w, r)
+		}
+		return
+	case isNewDocPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodPost) {
+			h.Doc(w, r)
+		}
+		return
+	case isIndexPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodHead, http.MethodPut, http.MethodDelete) {
+			h.Index(w, r)
+		}
+		return
+	case isAliasPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodPut, http.MethodDelete) {
+			h.Alias(w, r)
+		}
+		return
+	case isRolloverPath(r.URL.Path):
+		if h.allowMethods(w, r, http.MethodPost) {
+			h.Rollover(w, r)
+		}
+		return
+	case isIndexAPIPath(r.URL.Path, "_mapping"):
+		if h.allowMethods(w, r, http.MethodGet) {
+			h.Mapping(w, r)
+		}
+		return
+	case isIndexAPIPath(r.URL.Path, "_refresh"):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+			h.Refresh(w, r)
+		}
+		return
+	case isIndexAPIPath(r.URL.Path, "_flush"):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+			h.Flush(w, r)
+		}
+		return
+	case isIndexAPIPath(r.URL.Path, "_forcemerge"):
+		if h.allowMethods(w, r, http.MethodPost) {
+			h.ForceMerge(w, r)
+		}
+		return
+	case isIndexAPIPath(r.URL.Path, "_count"):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+			h.Count(w, r)
+		}
+		return
+	case isIndexAPIPath(r.URL.Path, "_msearch"):
+		if h.allowMethods(w, r, http.MethodGet, http.MethodPost) {
+			h.Msearch(w, r)
+		}
+		return
+	case r.URL.Path == "/_history":
+		if h.allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+			h.History(w, r)
+		}
 		return
 	default:
-		w.Write([]byte("{\"tagline\": \"You Know, for Testing\"}"))
+		h.Default(w, r)
 		return
 	}
 }
 
-// Bulk handles bulk posts
-func (h *APIHandler) Bulk(w http.ResponseWriter, r *http.Request) {
-	incrementCounter(bulkCreateTotalMetrics, h.metricsRegistry)
-	methodStatus := h.MethodOdds[rand.Intn(len(h.MethodOdds))]
-	if methodStatus == http.StatusRequestEntityTooLarge {
-		incrementCounter(bulkCreateTooLargeMetrics, h.metricsRegistry)
-		w.WriteHeader(methodStatus)
-		return
+// Default handles requests to any other path.  By default it replies with
+// the tagline, DefaultStatus and DefaultBody change the reply.
+func (h *APIHandler) Default(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(defaultTotalMetrics, h.metricsRegistry)
+	h.stats.add("default", 1)
+	status := h.DefaultStatus
+	if status == 0 {
+		status = http.StatusOK
 	}
-
-	var scanner *bufio.Scanner
-	br := BulkResponse{}
-	encoding, prs := r.Header[http.CanonicalHeaderKey("Content-Encoding")]
+	body := []byte(h.DefaultBody)
 	switch {
-	case prs && encoding[0] == "gzip":
-		zr, err := gzip.NewReader(r.Body)
-		if err != nil {
-			log.Printf("error new gzip reader failed: %s", err)
-			return
-		}
-		scanner = bufio.NewScanner(zr)
+	case h.DefaultBody != "":
+	case status < http.StatusMultipleChoices:
+		body = []byte("{\"tagline\": \"You Know, for Testing\"}")
 	default:
-		scanner = bufio.NewScanner(r.Body)
-	}
-	// bulk requests come in as 2 lines
-	// the action on first line, followed by the document on the next line.
-	// we only care about the action, which is why we have skipNextLine var
-	// eg:
-	// { "update": {"_id": "5", "_index": "index1"} }
-	// { "doc": {"my_field": "baz"} }
-
-	var skipNextLine bool
-	for scanner.Scan() {
-		b := scanner.Bytes()
-		if skipNextLine || len(b) == 0 {
-			skipNextLine = false
-			continue
-		}
-		var j map[string]any
-		err := json.Unmarshal(b, &j)
-		if err != nil {
-			log.Printf("error unmarshal: %s", err)
-			continue
-		}
-		if len(j) != 1 {
-			log.Printf("error, number of keys off: %d should be 1", len(j))
-			continue
-		}
-		for k := range j {
-			switch k {
-			case "index":
-				incrementCounter(bulkIndexTotalMetrics, h.metricsRegistry)
-				skipNextLine = true
-			case "create":
-				skipNextLine = true
-				actionStatus := h.ActionOdds[rand.Intn(len(h.ActionOdds))]
-				switch actionStatus {
-				case http.StatusOK:
-					incrementCounter(bulkCreateOkMetrics, h.metricsRegistry)
-				case http.StatusConflict:
-					br.Errors = true
-					incrementCounter(bulkCreateDuplicateMetrics, h.metricsRegistry)
-				case http.StatusTooManyRequests:
-					br.Errors = true
-					incrementCounter(bulkCreateTooManyMetrics, h.metricsRegistry)
-				case http.StatusNotAcceptable:
-					br.Errors = true
-					incrementCounter(bulkCreateNonIndexMetrics, h.metricsRegistry)
-				}
-				br.Items = append(br.Items, map[string]any{"created": map[string]any{"status": actionStatus}})
-			case "update":
-				incrementCounter(bulkUpdateTotalMetrics, h.metricsRegistry)
-				skipNextLine = true
-			case "delete":
-				incrementCounter(bulkDeleteTotalMetrics, h.metricsRegistry)
-				skipNextLine = false
-			}
-		}
-	}
-	brBytes, err := json.Marshal(br)
-	if err != nil {
-		log.Printf("error marshal bulk reply: %s", err)
+		// like Elasticsearch does for paths it has no handler for
+		writeJSON(w, status, map[string]any{
+			"error":  fmt.Sprintf("no handler found for uri [%s] and method [%s]", r.URL.RequestURI(), r.Method),
+			"status": status,
+		})
 		return
 	}
-	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
-	w.Write(brBytes)
+	w.WriteHeader(status)
+	w.Write(body)
 	return
 }
 
-// Root handles / get requests
+// isNamedPath returns true for the {prefix}/{name} endpoints, eg
+// /_index_template/{name}
+func isNamedPath(p, prefix string) bool {
+	name, ok := strings.CutPrefix(p, prefix+"/")
+	return ok && name != "" && !strings.Contains(name, "/")
+}
+
+// indexAPIPath returns the index of the /{api} and /{index}/{api} endpoints,
+// eg /_refresh, which is empty for the former, and whether p is one of them
+func indexAPIPath(p, api string) (string, bool) {
+	dir, file := path.Split(p)
+	if file != api {
+		return "", false
+	}
+	if dir == "/" {
+		return "", true
+	}
+	index := strings.Trim(dir, "/")
+	return index, strings.Count(dir, "/") == 2 && !strings.HasPrefix(index, "_")
+}
+
+// isIndexAPIPath returns true for the /{api} and /{index}/{api} endpoints
+func isIndexAPIPath(p, api string) bool {
+	_, ok := indexAPIPath(p, api)
+	return ok
+}
+
+// sleep waits for d, returning false if the client of r gave up before
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// delay returns Delay randomized by DelayJitter
+func (h *APIHandler) delay() time.Duration {
+	if h.DelayJitter <= 0 {
+		return h.Delay
+	}
+	return time.Duration(float64(h.Delay) * (1 + h.DelayJitter*(2*rand.Float64()-1)))
+}
+
+// allowMethods returns true if the request method is one of methods.
+// Otherwise it replies with StatusMethodNotAllowed, like Elasticsearch does
+// for a known path with the wrong method, and returns false.
+func (h *APIHandler) allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	incrementCounter(methodNotAllowedMetrics, h.metricsRegistry)
+	allowed := strings.Join(methods, ",")
+	w.Header().Set(http.CanonicalHeaderKey("Allow"), allowed)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
+		"error":  fmt.Sprintf("Incorrect HTTP method for uri [%s] and method [%s], allowed: [%s]", r.URL.RequestURI(), r.Method, allowed),
+		"status": http.StatusMethodNotAllowed,
+	})
+	return false
+}
+
+// version returns Version, or the version of the client's User-Agent if
+// Version is empty
+func (h *APIHandler) version(r *http.Request) string {
+	if h.Version != "" {
+		return h.Version
+	}
+	return useragent.Parse(r.Header.Get("User-Agent")).VersionNoFull()
+}
+
+// Root handles / get and head requests.  The version is Version, or the version
+// of the client's User-Agent if Version is empty, with build and compatibility
+// details derived from it.
 func (h *APIHandler) Root(w http.ResponseWriter, r *http.Request) {
 	incrementCounter(rootTotalMetrics, h.metricsRegistry)
-	ua := useragent.Parse(r.Header.Get("User-Agent"))
-	root := fmt.Sprintf("{\"name\" : \"mock\", \"cluster_uuid\" : \"%s\", \"version\" : { \"number\" : \"%s\", \"build_flavor\" : \"default\"}}", h.ClusterUUID, ua.VersionNoFull())
-	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
-	w.Write([]byte(root))
+	h.stats.add("root", 1)
+	h.UserAgentTracker.SeenRoot(h.UserAgentTracker.normalize(r.UserAgent()))
+	if r.Method == http.MethodHead {
+		// like Elasticsearch, the headers are those of the get response
+		root, err := json.Marshal(h.rootResponse(r))
+		if err != nil {
+			log.Printf("error marshal root reply: %s", err)
+			return
+		}
+		w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
+		w.Header().Set(http.CanonicalHeaderKey("Content-Length"), strconv.Itoa(len(root)))
+		return
+	}
+	writeJSON(w, http.StatusOK, h.rootResponse(r))
 	return
 }
 
 // License handles /_license get requests
 func (h *APIHandler) License(w http.ResponseWriter, r *http.Request) {
 	incrementCounter(licenseTotalMetrics, h.metricsRegistry)
-	license := fmt.Sprintf("{\"license\" : {\"status\" : \"active\", \"uid\" : \"%s\", \"type\" : \"trial\", \"expiry_date_in_millis\" : %d}}", h.UUID.String(), h.Expire.UnixMilli())
+	h.stats.add("license", 1)
+	h.UserAgentTracker.SeenLicense(h.UserAgentTracker.normalize(r.UserAgent()))
+	status, expire := h.license()
+	writeJSON(w, http.StatusOK, LicenseResponse{License: LicenseInfo{Status: status, UID: h.UUID.String(), Type: h.LicenseType, ExpiryDateInMillis: expire.UnixMilli()}})
+	return
+}
+
+// HeapUsage returns the simulated heap in bytes, which grows with the
+// size of the stored documents and the number of requests being handled.
+func (h *APIHandler) HeapUsage() int64 {
+	heap := h.inflight.Load() * inflightRequestHeap
+	if h.Store != nil {
+		heap += h.Store.Size()
+	}
+	return heap
+}
+
+// History handles /_history get requests by returning the recorded requests,
+// and delete requests by clearing them
+func (h *APIHandler) History(w http.ResponseWriter, r *http.Request) {
+	if h.RequestHistory == nil {
+		writeJSON(w, http.StatusOK, []RequestRecord{})
+		return
+	}
+	if r.Method == http.MethodDelete {
+		h.RequestHistory.Reset()
+	}
+	// the records of a file history are streamed from its files, so they
+	// are not marshaled by writeJSON
 	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
-	w.Write([]byte(license))
+	if err := h.RequestHistory.WriteJSON(w); err != nil {
+		log.Printf("error writing history reply: %s", err)
+	}
+	return
+}
+
+// UserAgents handles /_mock/useragents get requests by returning the number
+// of requests per user agent for each endpoint
+func (h *APIHandler) UserAgents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.UserAgentTracker.Get())
 	return
 }
 
+// isPretty returns true if the response to r is pretty printed, when it has
+// the pretty query parameter and it is not false
+func isPretty(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Has("pretty") && q.Get("pretty") != "false"
+}
+
+// writeJSON writes v as a JSON response with status.  If v can't be
+// marshaled the error is logged and the response is an Elasticsearch style
+// StatusInternalServerError.  The pretty and filter_path query parameters
+// are applied by ServeHTTP.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("error marshal %T reply: %s", v, err)
+		status = http.StatusInternalServerError
+		cause := ErrorCause{Type: "exception", Reason: fmt.Sprintf("failed to marshal reply: %s", err)}
+		b, _ = json.Marshal(ErrorResponse{Error: ErrorCause{RootCause: []ErrorCause{cause}, Type: cause.Type, Reason: cause.Reason}, Status: status})
+	}
+	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// writeError writes an Elasticsearch error response with the given status
+func (h *APIHandler) writeError(w http.ResponseWriter, status int, errType, reason string) {
+	cause := ErrorCause{Type: errType, Reason: reason}
+	er := ErrorResponse{Error: ErrorCause{RootCause: []ErrorCause{cause}, Type: errType, Reason: reason}, Status: status}
+	if (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) && h.RetryAfter > 0 {
+		w.Header().Set(http.CanonicalHeaderKey("Retry-After"), strconv.Itoa(int(math.Ceil(h.RetryAfter.Seconds()))))
+	}
+	writeJSON(w, status, er)
+}
+
 func incrementCounter(counterName string, registry metrics.Registry) {
 	m := metrics.GetOrRegisterCounter(counterName, registry)
 	m.Inc(1)
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rcrowley/go-metrics"
+)
+
+// newTestHandler returns a handler without errors or delay
+func newTestHandler() *APIHandler {
+	return NewAPIHandler(uuid.New(), "", metrics.NewRegistry(), time.Now().Add(24*time.Hour), 0, 0, 0, 0, 0)
+}
+
+// productHeader returns the X-Elastic-Product header values of the
+// response of h to GET /
+func productHeader(t *testing.T, h *APIHandler) []string {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("GET / failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET / status is %d, want 200", resp.StatusCode)
+	}
+	return resp.Header.Values("X-Elastic-Product")
+}
+
+func TestProductHeaderDefault(t *testing.T) {
+	if values := productHeader(t, newTestHandler()); len(values) != 1 || values[0] != "Elasticsearch" {
+		t.Errorf("X-Elastic-Product is %q, want Elasticsearch", values)
+	}
+}
+
+func TestProductHeaderOmitted(t *testing.T) {
+	h := newTestHandler()
+	h.ProductHeader = ""
+	if values := productHeader(t, h); len(values) != 0 {
+		t.Errorf("X-Elastic-Product is %q, want no header", values)
+	}
+}
+
+func TestProductHeaderOther(t *testing.T) {
+	h := newTestHandler()
+	h.ProductHeader = "OpenSearch"
+	if values := productHeader(t, h); len(values) != 1 || values[0] != "OpenSearch" {
+		t.Errorf("X-Elastic-Product is %q, want OpenSearch", values)
+	}
+}
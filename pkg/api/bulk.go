@@ -0,0 +1,849 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/rcrowley/go-metrics"
+)
+
+// DefaultMaxLineSize is the maximum size in bytes of a bulk request line
+// when MaxLineSize is not set
+const DefaultMaxLineSize = 100 << 20
+
+// bulkAction is an action line of a bulk request together with the
+// document line that follows it for index, create and update actions.
+// eg:
+// { "update": {"_id": "5", "_index": "index1"} }
+// { "doc": {"my_field": "baz"} }
+type bulkAction struct {
+	action   string
+	meta     map[string]any
+	index    string
+	id       string
+	doc      []byte
+	source   map[string]any
+	pipeline string
+	line     int
+	// healthy actions get none of the random faults
+	healthy bool
+}
+
+// malformedBulkError is returned by bulkReader in strict mode when the
+// body of a bulk request can't be parsed.  The error type is
+// illegal_argument_exception unless errType is set.
+type malformedBulkError struct {
+	errType string
+	reason  string
+}
+
+func (e *malformedBulkError) Error() string {
+	return e.reason
+}
+
+// bulkReader reads the actions of a bulk request body.  Unless strict is
+// set, malformed lines are logged and skipped, and the body doesn't have to
+// end with a newline.  If parseDocs is set the
+// document lines are unmarshalled into the source of the actions.
+// Actions without a pipeline get the pipeline of the request, and the
+// actions of a healthy request get none of the random faults.
+type bulkReader struct {
+	scanner   *bufio.Scanner
+	body      *countingReader
+	strict    bool
+	parseDocs bool
+	pathIndex string
+	pipeline  string
+	healthy   bool
+	line      int
+}
+
+// next returns the next action of the body, or io.EOF when there are no
+// more actions.  In strict mode a malformedBulkError is returned when the
+// body is malformed.
+func (br *bulkReader) next() (*bulkAction, error) {
+	for br.scanner.Scan() {
+		br.line++
+		b := br.trimmedLine()
+		if len(b) == 0 {
+			continue
+		}
+		var j map[string]any
+		err := json.Unmarshal(b, &j)
+		if err != nil {
+			log.Printf("error unmarshal: %s", err)
+			if br.strict {
+				return nil, &malformedBulkError{reason: fmt.Sprintf("Malformed action/metadata line [%d], expected a JSON object but found [%s]", br.line, b)}
+			}
+			continue
+		}
+		if len(j) != 1 {
+			log.Printf("error, number of keys off: %d should be 1", len(j))
+			if br.strict {
+				return nil, &malformedBulkError{reason: fmt.Sprintf("Malformed action/metadata line [%d], expected a single action but found [%d]", br.line, len(j))}
+			}
+			continue
+		}
+		a := &bulkAction{line: br.line, healthy: br.healthy}
+		for k, v := range j {
+			a.action = k
+			a.meta, _ = v.(map[string]any)
+		}
+		a.index, _ = a.meta["_index"].(string)
+		if a.index == "" {
+			a.index = br.pathIndex
+		}
+		a.id, _ = a.meta["_id"].(string)
+		a.pipeline, _ = a.meta["pipeline"].(string)
+		if a.pipeline == "" {
+			a.pipeline = br.pipeline
+		}
+
+		switch a.action {
+		case "index", "create", "update":
+			if !br.scanner.Scan() {
+				if err := br.readErr(); err != nil {
+					return nil, err
+				}
+				if br.strict {
+					return nil, &malformedBulkError{reason: fmt.Sprintf("Malformed action/metadata line [%d], expected a document on the next line", a.line)}
+				}
+				return a, nil
+			}
+			br.line++
+			a.doc = br.trimmedLine()
+			if len(a.doc) == 0 && br.strict {
+				return nil, &malformedBulkError{reason: fmt.Sprintf("Malformed action/metadata line [%d], expected a document on the next line", a.line)}
+			}
+			if br.parseDocs && len(a.doc) != 0 {
+				if err := json.Unmarshal(a.doc, &a.source); err != nil {
+					log.Printf("error unmarshal document on line %d: %s", br.line, err)
+				}
+			}
+		case "delete":
+		default:
+			if br.strict {
+				return nil, &malformedBulkError{reason: fmt.Sprintf("Malformed action/metadata line [%d], expected one of [create, delete, index, update] but found [%s]", br.line, a.action)}
+			}
+			continue
+		}
+		return a, nil
+	}
+	if err := br.readErr(); err != nil {
+		return nil, err
+	}
+	if br.strict && br.body != nil {
+		// like Elasticsearch, an empty body is missing rather than
+		// unterminated
+		if br.body.n == 0 {
+			return nil, &malformedBulkError{errType: "parse_exception", reason: "request body is required"}
+		}
+		if br.body.last != '\n' {
+			return nil, &malformedBulkError{reason: "The bulk request must be terminated by a newline [\\n]"}
+		}
+	}
+	return nil, io.EOF
+}
+
+// trimmedLine returns the line read by the last Scan without surrounding
+// whitespace.  bufio.ScanLines only drops the \r of a \r\n line ending, so
+// stray \r and whitespace left by clients are removed here.
+func (br *bulkReader) trimmedLine() []byte {
+	return bytes.TrimSpace(br.scanner.Bytes())
+}
+
+// readErr returns nil if the body was read to the end.  Otherwise reading
+// stopped early, eg at a line longer than the scanner buffer, and it
+// returns a malformedBulkError in strict mode and io.EOF otherwise.
+func (br *bulkReader) readErr() error {
+	err := br.scanner.Err()
+	if err == nil {
+		return nil
+	}
+	log.Printf("error reading bulk body after line %d: %s", br.line, err)
+	if br.strict {
+		return &malformedBulkError{reason: fmt.Sprintf("Failed to read line [%d]: %s", br.line+1, err)}
+	}
+	return io.EOF
+}
+
+// unsupportedEncodingError is returned for a Content-Encoding the body
+// can't be decompressed from
+type unsupportedEncodingError struct {
+	encoding string
+}
+
+func (e *unsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding [%s], supported encodings are [gzip, deflate, zstd]", e.encoding)
+}
+
+// decodedBody returns the body of r, decompressed if it is gzip, deflate or
+// zstd encoded
+func decodedBody(r *http.Request) (io.Reader, error) {
+	return decodeBody(r.Header, r.Body)
+}
+
+// decodeBody returns body decompressed per the Content-Encoding of header.
+// Like Elasticsearch, deflate is the zlib format.
+func decodeBody(header http.Header, body io.Reader) (io.Reader, error) {
+	switch encoding := header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		return zlib.NewReader(body)
+	case "zstd":
+		// a single goroutine decodes synchronously, so the decoder
+		// doesn't need to be closed
+		return zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+	default:
+		return nil, &unsupportedEncodingError{encoding: encoding}
+	}
+}
+
+// writeDecodeError writes the error of a body that can't be decompressed,
+// StatusUnsupportedMediaType for an unsupported Content-Encoding, otherwise
+// StatusBadRequest
+func (h *APIHandler) writeDecodeError(w http.ResponseWriter, err error) {
+	var uee *unsupportedEncodingError
+	if errors.As(err, &uee) {
+		h.writeError(w, http.StatusUnsupportedMediaType, "illegal_argument_exception", err.Error())
+		return
+	}
+	h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("failed to decompress request body: %s", err))
+}
+
+// countingReader counts the bytes read from an io.Reader, so bodies are
+// measured as they are read whether they have a Content-Length or are
+// chunked.  It keeps the last byte read to check how a body ends.
+type countingReader struct {
+	r    io.Reader
+	n    int64
+	last byte
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if n > 0 {
+		c.last = p[n-1]
+	}
+	return n, err
+}
+
+// countBulkBytes adds the bytes read from the wire and after decompression
+// to the bulk.bytes metrics
+func (h *APIHandler) countBulkBytes(received, decoded *countingReader) {
+	metrics.GetOrRegisterCounter(bulkBytesReceivedMetrics, h.metricsRegistry).Inc(received.n)
+	metrics.GetOrRegisterCounter(bulkBytesDecodedMetrics, h.metricsRegistry).Inc(decoded.n)
+}
+
+// newLineScanner returns a scanner of the lines of an NDJSON body, eg of a
+// bulk request, which fails on lines longer than MaxLineSize
+func (h *APIHandler) newLineScanner(body io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(body)
+	maxLineSize := h.MaxLineSize
+	if maxLineSize <= 0 {
+		maxLineSize = DefaultMaxLineSize
+	}
+	scanner.Buffer(make([]byte, 0, min(maxLineSize, bufio.MaxScanTokenSize)), maxLineSize)
+	return scanner
+}
+
+// rejectBulk counts a bulk request and fails it as a whole per FailAfter
+// and MethodOdds.  It returns true if the request was rejected, and whether
+// the request is within HealthyFor and gets none of the random faults.
+func (h *APIHandler) rejectBulk(w http.ResponseWriter) (healthy, rejected bool) {
+	n := h.bulkRequests.Add(1)
+	if h.FailAfter > 0 && n > h.FailAfter {
+		incrementCounter(bulkFailedAfterMetrics, h.metricsRegistry)
+		h.writeError(w, http.StatusServiceUnavailable, "cluster_block_exception", "blocked by: [SERVICE_UNAVAILABLE/2/no master];")
+		return false, true
+	}
+	healthy = n <= h.HealthyFor
+	methodStatus := http.StatusOK
+	if !healthy {
+		methodStatus = h.MethodOdds[rand.Intn(len(h.MethodOdds))]
+	}
+	if methodStatus < http.StatusMultipleChoices {
+		return healthy, false
+	}
+	reason := http.StatusText(methodStatus)
+	switch methodStatus {
+	case http.StatusRequestEntityTooLarge:
+		incrementCounter(bulkCreateTooLargeMetrics, h.metricsRegistry)
+	case http.StatusTooManyRequests:
+		incrementCounter(bulkRejectedMetrics, h.metricsRegistry)
+		reason = "rejected execution of coordinating operation, write queue is full"
+		if h.RetryAfter == 0 {
+			// clients are told to back off even without -retry-after
+			w.Header().Set(http.CanonicalHeaderKey("Retry-After"), "1")
+		}
+	}
+	h.writeError(w, methodStatus, methodErrorType(methodStatus), reason)
+	return healthy, true
+}
+
+// Bulk handles bulk post and put requests
+func (h *APIHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	incrementCounter(bulkCreateTotalMetrics, h.metricsRegistry)
+	h.stats.add("bulk", 1)
+	agent := h.UserAgentTracker.normalize(r.UserAgent())
+	h.UserAgentTracker.SeenBulk(agent)
+	healthy, rejected := h.rejectBulk(w)
+	if rejected {
+		return
+	}
+
+	if h.StrictBulk {
+		if reason := validateBulkParams(r); reason != "" {
+			h.malformedBulk(w, &malformedBulkError{reason: reason})
+			return
+		}
+	}
+
+	br := BulkResponse{}
+	received := &countingReader{r: r.Body}
+	decodedReader, err := decodeBody(r.Header, received)
+	if err != nil {
+		h.writeDecodeError(w, err)
+		return
+	}
+	decoded := &countingReader{r: decodedReader}
+	defer h.countBulkBytes(received, decoded)
+	var body io.Reader = decoded
+	// the body is read before any action is performed, so a body that is
+	// too large has no effect, like in Elasticsearch
+	if h.MaxContentLength > 0 {
+		b, err := io.ReadAll(io.LimitReader(body, h.MaxContentLength+1))
+		if err != nil {
+			log.Printf("error reading bulk body: %s", err)
+			return
+		}
+		if int64(len(b)) > h.MaxContentLength {
+			incrementCounter(bulkCreateTooLargeMetrics, h.metricsRegistry)
+			h.writeError(w, http.StatusRequestEntityTooLarge, "content_too_long_exception", fmt.Sprintf("request body is too large, exceeds the maximum content length of [%d] bytes", h.MaxContentLength))
+			return
+		}
+		body = bytes.NewReader(b)
+	}
+	scanner := h.newLineScanner(body)
+
+	_, pathIndex := path.Split(path.Dir(r.URL.Path))
+	reader := &bulkReader{scanner: scanner, body: decoded, strict: h.StrictBulk, parseDocs: h.ItemStatusFunc != nil, pathIndex: pathIndex, pipeline: r.URL.Query().Get("pipeline"), healthy: healthy}
+	if h.StreamBulk {
+		h.streamBulk(w, r, reader, agent, start)
+		return
+	}
+	typed := false
+	for {
+		a, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var mbe *malformedBulkError
+		if errors.As(err, &mbe) {
+			h.malformedBulk(w, mbe)
+			return
+		}
+		typed = typed || hasType(a)
+		item := h.bulkActionItem(r, a, agent)
+		if item == nil {
+			continue
+		}
+		br.Errors = br.Errors || itemFailed(item)
+		br.Items = append(br.Items, item)
+	}
+	if !h.waitForRefresh(r) {
+		return
+	}
+	h.setTook(&br, r, start)
+	if typed {
+		h.addWarning(w, r, bulkTypesDeprecation)
+	}
+	writeJSON(w, http.StatusOK, br)
+	return
+}
+
+// streamBulk writes the response to a bulk request item by item, flushing
+// each item as soon as its action is performed.  The items come first so
+// took and errors, which are only known at the end, are written last.  In
+// strict mode a malformed body after the first item has been written aborts
+// the response, as the status can't be changed anymore.
+func (h *APIHandler) streamBulk(w http.ResponseWriter, r *http.Request, reader *bulkReader, agent string, start time.Time) {
+	rc := http.NewResponseController(w)
+	br := BulkResponse{}
+	started, typed := false, false
+	for {
+		a, err := reader.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var mbe *malformedBulkError
+		if errors.As(err, &mbe) {
+			if !started {
+				h.malformedBulk(w, mbe)
+				return
+			}
+			incrementCounter(bulkMalformedMetrics, h.metricsRegistry)
+			log.Printf("error malformed bulk body after streaming started: %s", mbe.reason)
+			panic(http.ErrAbortHandler)
+		}
+		// the headers are sent with the first item, so a later _type
+		// can't be warned about
+		if !started && !typed && hasType(a) {
+			typed = true
+			h.addWarning(w, r, bulkTypesDeprecation)
+		}
+		item := h.bulkActionItem(r, a, agent)
+		if item == nil {
+			continue
+		}
+		br.Errors = br.Errors || itemFailed(item)
+		itemBytes, err := json.Marshal(item)
+		if err != nil {
+			log.Printf("error marshal bulk item: %s", err)
+			continue
+		}
+		if !started {
+			w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
+			w.Write([]byte("{\"items\":["))
+			started = true
+		} else {
+			w.Write([]byte(","))
+		}
+		w.Write(itemBytes)
+		if err := rc.Flush(); err != nil {
+			log.Printf("error flushing bulk item: %s", err)
+		}
+	}
+	if !started {
+		w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
+		w.Write([]byte("{\"items\":["))
+	}
+	if !h.waitForRefresh(r) {
+		return
+	}
+	h.setTook(&br, r, start)
+	// br has no items, so its fields can be appended after the items
+	brBytes, err := json.Marshal(br)
+	if err != nil {
+		log.Printf("error marshal bulk reply: %s", err)
+		return
+	}
+	w.Write([]byte("],"))
+	w.Write(brBytes[1:])
+	return
+}
+
+// setTook sets took and ingest_took of br for a bulk request r started at
+// start.  took includes the configured delay, ingest_took is only reported
+// when a pipeline was requested, like Elasticsearch does.
+func (h *APIHandler) setTook(br *BulkResponse, r *http.Request, start time.Time) {
+	took := time.Since(start)
+	br.Took = (took + h.Delay).Milliseconds()
+	if r.URL.Query().Get("pipeline") != "" {
+		ingestTook := took.Milliseconds()
+		br.IngestTook = &ingestTook
+	}
+}
+
+// itemFailed returns true if the status of the bulk response item is an
+// error status
+func itemFailed(item map[string]any) bool {
+	for _, result := range item {
+		if status, _ := result.(map[string]any)["status"].(int); status >= http.StatusMultipleChoices {
+			return true
+		}
+	}
+	return false
+}
+
+// bulkItemsKey is the context key of the counter of the bulk actions of a
+// request
+type bulkItemsKey struct{}
+
+// WithBulkItemsCounter returns a copy of ctx in which the actions of a bulk
+// request are counted into n, eg to add them to the span of the request
+func WithBulkItemsCounter(ctx context.Context, n *atomic.Int64) context.Context {
+	return context.WithValue(ctx, bulkItemsKey{}, n)
+}
+
+// bulkActionItem performs a and returns its response item, or nil if the
+// action has no item in the response.  Only create actions and failed
+// actions have items, unless a status func is set, then index and update
+// actions always have one too.
+func (h *APIHandler) bulkActionItem(r *http.Request, a *bulkAction, agent string) map[string]any {
+	incrementCounter(bulkItemsTotalMetrics, h.metricsRegistry)
+	if n, ok := r.Context().Value(bulkItemsKey{}).(*atomic.Int64); ok {
+		n.Add(1)
+	}
+	if h.IndexMetrics != "" && a.index != "" && matchName(h.IndexMetrics, a.index) {
+		incrementCounter("bulk.items.index."+a.index+".total", h.metricsRegistry)
+	}
+	h.stats.add("bulk_items", 1)
+	switch a.action {
+	case "index":
+		incrementCounter(bulkIndexTotalMetrics, h.metricsRegistry)
+		h.UserAgentTracker.SeenIndex(agent)
+		if h.autoCreateDenied(a) {
+			return h.indexNotFoundItem("index", a)
+		}
+		if h.pipelineFails(a) {
+			return h.pipelineFailureItem("index", a)
+		}
+		status := h.itemStatus(a)
+		if status < http.StatusMultipleChoices {
+			h.storeDocument(a)
+		}
+		if h.ItemStatusFunc == nil {
+			return nil
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		return bulkItem("index", status)
+	case "create":
+		h.UserAgentTracker.SeenCreate(agent)
+		if h.autoCreateDenied(a) {
+			return h.indexNotFoundItem("created", a)
+		}
+		if h.pipelineFails(a) {
+			return h.pipelineFailureItem("created", a)
+		}
+		if shard := h.shardFor(a.meta); shard == h.RejectShard && !a.healthy {
+			incrementCounter(bulkCreateShardRejectedMetrics, h.metricsRegistry)
+			return map[string]any{"created": map[string]any{
+				"status": http.StatusTooManyRequests,
+				"error": map[string]any{
+					"type":   "es_rejected_execution_exception",
+					"reason": fmt.Sprintf("rejected execution of primary operation on shard [%d], write queue is full", shard),
+				},
+			}}
+		}
+		item := map[string]any{}
+		actionStatus := h.itemStatus(a)
+		switch {
+		case actionStatus != 0:
+			item = bulkItem("created", actionStatus)
+		case a.healthy:
+			actionStatus = http.StatusOK
+			item["created"] = map[string]any{"status": actionStatus}
+		default:
+			actionStatus = h.ActionOdds[rand.Intn(len(h.ActionOdds))]
+			item["created"] = map[string]any{"status": actionStatus}
+		}
+		switch actionStatus {
+		case http.StatusOK:
+			incrementCounter(bulkCreateOkMetrics, h.metricsRegistry)
+			h.storeDocument(a)
+		case http.StatusConflict:
+			incrementCounter(bulkCreateDuplicateMetrics, h.metricsRegistry)
+		case http.StatusTooManyRequests:
+			incrementCounter(bulkCreateTooManyMetrics, h.metricsRegistry)
+		case http.StatusNotAcceptable:
+			incrementCounter(bulkCreateNonIndexMetrics, h.metricsRegistry)
+		}
+		return item
+	case "update":
+		incrementCounter(bulkUpdateTotalMetrics, h.metricsRegistry)
+		h.UserAgentTracker.SeenUpdate(agent)
+		if h.autoCreateDenied(a) {
+			return h.indexNotFoundItem("update", a)
+		}
+		status := h.itemStatus(a)
+		if status < http.StatusMultipleChoices && !h.updateDocument(a) {
+			return h.documentMissingItem(a)
+		}
+		if h.ItemStatusFunc == nil {
+			return nil
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		return bulkItem("update", status)
+	case "delete":
+		incrementCounter(bulkDeleteTotalMetrics, h.metricsRegistry)
+		h.UserAgentTracker.SeenDelete(agent)
+		if h.autoCreateDenied(a) {
+			return h.indexNotFoundItem("delete", a)
+		}
+		if h.Store != nil {
+			h.Store.Delete(a.index, a.id)
+		}
+	}
+	return nil
+}
+
+// itemStatus returns the status ItemStatusFunc picks for a, or 0 if there
+// is no ItemStatusFunc
+func (h *APIHandler) itemStatus(a *bulkAction) int {
+	if h.ItemStatusFunc == nil {
+		return 0
+	}
+	return h.ItemStatusFunc(a.action, a.meta, a.source)
+}
+
+// pipelineFails returns true if a is processed by FailingPipeline.  Only
+// index and create actions run ingest pipelines.
+func (h *APIHandler) pipelineFails(a *bulkAction) bool {
+	return h.FailingPipeline != "" && a.pipeline == h.FailingPipeline
+}
+
+// pipelineFailureItem returns the bulk response item for action a whose
+// pipeline failed, with the error a fail processor throws in Elasticsearch
+func (h *APIHandler) pipelineFailureItem(action string, a *bulkAction) map[string]any {
+	incrementCounter(bulkPipelineFailedMetrics, h.metricsRegistry)
+	cause := map[string]any{
+		"type":   "fail_processor_exception",
+		"reason": fmt.Sprintf("pipeline [%s] failed", a.pipeline),
+		"header": map[string]any{
+			"processor_type":  "fail",
+			"pipeline_origin": []string{a.pipeline},
+		},
+	}
+	err := map[string]any{"root_cause": []any{cause}}
+	for k, v := range cause {
+		err[k] = v
+	}
+	return map[string]any{action: map[string]any{
+		"status": http.StatusInternalServerError,
+		"error":  err,
+	}}
+}
+
+// indexNotFoundItem returns the bulk response item for action a whose
+// index doesn't exist and can't be auto-created
+func (h *APIHandler) indexNotFoundItem(action string, a *bulkAction) map[string]any {
+	incrementCounter(bulkIndexNotFoundMetrics, h.metricsRegistry)
+	return map[string]any{action: map[string]any{
+		"status": http.StatusNotFound,
+		"error": map[string]any{
+			"type":   "index_not_found_exception",
+			"reason": fmt.Sprintf("no such index [%s]", a.index),
+			"index":  a.index,
+		},
+	}}
+}
+
+// storeDocument records that the index of a exists, infers its mappings
+// with InferMappings, and keeps the document of a in the Store, if there is
+// one.  It returns the stored document, which has version 1 without a
+// Store, and true if it was created rather than replaced.
+func (h *APIHandler) storeDocument(a *bulkAction) (StoredDocument, bool) {
+	if a.index != "" {
+		h.indices.add(a.index, false)
+	}
+	// the document of an update is partial
+	if h.InferMappings && a.index != "" && a.action != "update" && len(a.doc) != 0 {
+		h.inferred.infer(a.index, a.doc)
+	}
+	if a.id == "" {
+		a.id = uuid.NewString()
+	}
+	if h.Store == nil || len(a.doc) == 0 {
+		return StoredDocument{Index: a.index, ID: a.id, Version: 1}, true
+	}
+	return h.Store.Index(a.index, a.id, a.doc)
+}
+
+// updateRequest is the document line of an update action
+type updateRequest struct {
+	Doc         map[string]any `json:"doc"`
+	Upsert      map[string]any `json:"upsert"`
+	DocAsUpsert bool           `json:"doc_as_upsert"`
+}
+
+// updateDocument performs the update action a on its stored document: doc
+// is merged into its source, and a missing document is created from
+// upsert, or from doc with doc_as_upsert.  Scripts are not run, they leave
+// the source unchanged.  It returns false if the document is missing and
+// there is no upsert.  Without a Store documents are never missing.
+func (h *APIHandler) updateDocument(a *bulkAction) bool {
+	if h.Store == nil {
+		h.storeDocument(a)
+		return true
+	}
+	var ur updateRequest
+	d := json.NewDecoder(bytes.NewReader(a.doc))
+	d.UseNumber()
+	if err := d.Decode(&ur); err != nil {
+		log.Printf("error unmarshal update of %s in %s: %s", a.id, a.index, err)
+	}
+	var source map[string]any
+	action := "update"
+	if sd, ok := h.Store.Get(a.index, a.id); ok {
+		d := json.NewDecoder(bytes.NewReader(sd.Source))
+		d.UseNumber()
+		if err := d.Decode(&source); err != nil {
+			log.Printf("error unmarshal stored document %s in %s: %s", a.id, a.index, err)
+		}
+		source = mergeSource(source, ur.Doc)
+	} else {
+		switch {
+		case ur.Upsert != nil:
+			source = ur.Upsert
+		case ur.DocAsUpsert && ur.Doc != nil:
+			source = ur.Doc
+		default:
+			return false
+		}
+		action = "index"
+	}
+	doc, err := json.Marshal(source)
+	if err != nil {
+		log.Printf("error marshal updated document %s in %s: %s", a.id, a.index, err)
+		return true
+	}
+	// an upserted document is whole, its mappings can be inferred
+	h.storeDocument(&bulkAction{action: action, index: a.index, id: a.id, doc: doc})
+	return true
+}
+
+// mergeSource merges the fields of doc into source, recursively for
+// objects, like a partial update of Elasticsearch, and returns source
+func mergeSource(source, doc map[string]any) map[string]any {
+	if source == nil {
+		source = make(map[string]any, len(doc))
+	}
+	for field, v := range doc {
+		if obj, ok := v.(map[string]any); ok {
+			if sourceObj, ok := source[field].(map[string]any); ok {
+				source[field] = mergeSource(sourceObj, obj)
+				continue
+			}
+		}
+		source[field] = v
+	}
+	return source
+}
+
+// documentMissingItem returns the item of the update action a of a missing
+// document
+func (h *APIHandler) documentMissingItem(a *bulkAction) map[string]any {
+	return map[string]any{"update": map[string]any{
+		"status": http.StatusNotFound,
+		"error": map[string]any{
+			"type":   "document_missing_exception",
+			"reason": fmt.Sprintf("[%s]: document missing", a.id),
+			"index":  a.index,
+			"shard":  strconv.Itoa(h.shardFor(a.meta)),
+		},
+	}}
+}
+
+// bulkItem returns a bulk response item for action with status, and an
+// error matching the status if it is an error status
+func bulkItem(action string, status int) map[string]any {
+	result := map[string]any{"status": status}
+	if status >= http.StatusMultipleChoices {
+		result["error"] = map[string]any{
+			"type":   itemErrorType(status),
+			"reason": http.StatusText(status),
+		}
+	}
+	return map[string]any{action: result}
+}
+
+// itemErrorType returns the Elasticsearch error type for a failed bulk item status
+func itemErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "mapper_parsing_exception"
+	case http.StatusNotFound:
+		return "index_not_found_exception"
+	case http.StatusConflict:
+		return "version_conflict_engine_exception"
+	case http.StatusTooManyRequests:
+		return "es_rejected_execution_exception"
+	default:
+		return "exception"
+	}
+}
+
+// methodErrorType returns the Elasticsearch error type for the status of a
+// request that failed as a whole, eg a bulk request
+func methodErrorType(status int) string {
+	switch status {
+	case http.StatusRequestEntityTooLarge:
+		return "content_too_long_exception"
+	case http.StatusTooManyRequests:
+		return "es_rejected_execution_exception"
+	case http.StatusServiceUnavailable:
+		return "cluster_block_exception"
+	default:
+		return "exception"
+	}
+}
+
+// IsBulkPath returns true for the /_bulk and /{index}/_bulk endpoints
+func IsBulkPath(p string) bool {
+	dir, file := path.Split(p)
+	return file == "_bulk" && (dir == "/" || strings.Count(dir, "/") == 2)
+}
+
+// shardFor returns the shard an action is routed to.  Like Elasticsearch
+// the routing value is hashed if present, otherwise the document _id.
+// Actions with neither get a random shard, as an auto-generated _id would.
+func (h *APIHandler) shardFor(meta map[string]any) int {
+	if h.Shards <= 1 {
+		return 0
+	}
+	key, ok := meta["routing"].(string)
+	if !ok {
+		key, ok = meta["_id"].(string)
+	}
+	if !ok {
+		return rand.Intn(h.Shards)
+	}
+	f := fnv.New32a()
+	f.Write([]byte(key))
+	return int(f.Sum32() % uint32(h.Shards))
+}
+
+// validateBulkParams returns the reason the bulk query parameters of r are
+// invalid, or an empty string if they are valid
+func validateBulkParams(r *http.Request) string {
+	q := r.URL.Query()
+	switch refresh := q.Get("refresh"); refresh {
+	case "", "true", "false", "wait_for":
+	default:
+		return fmt.Sprintf("Unknown value for refresh: [%s].", refresh)
+	}
+	if requireAlias := q.Get("require_alias"); requireAlias != "" {
+		if _, err := strconv.ParseBool(requireAlias); err != nil {
+			return fmt.Sprintf("Failed to parse value [%s] as only [true] or [false] are allowed.", requireAlias)
+		}
+	}
+	return ""
+}
+
+// malformedBulk replies to a bulk request whose body could not be parsed
+func (h *APIHandler) malformedBulk(w http.ResponseWriter, mbe *malformedBulkError) {
+	incrementCounter(bulkMalformedMetrics, h.metricsRegistry)
+	errType := mbe.errType
+	if errType == "" {
+		errType = "illegal_argument_exception"
+	}
+	h.writeError(w, http.StatusBadRequest, errType, mbe.reason)
+}
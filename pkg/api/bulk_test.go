@@ -0,0 +1,409 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rcrowley/go-metrics"
+)
+
+// postBulk posts body to the /_bulk endpoint of url with the
+// Content-Encoding encoding, if not empty, and returns the response status
+// and decoded body
+func postBulk(t *testing.T, url, encoding string, body io.Reader) (int, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+"/_bulk", body)
+	if err != nil {
+		t.Fatalf("error creating bulk request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("bulk request failed: %s", err)
+	}
+	defer resp.Body.Close()
+	var reply map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatalf("bulk response doesn't decode: %s", err)
+	}
+	return resp.StatusCode, reply
+}
+
+// errorType returns the type of the error of an error reply
+func errorType(reply map[string]any) string {
+	e, _ := reply["error"].(map[string]any)
+	errType, _ := e["type"].(string)
+	return errType
+}
+
+func TestItemStatusFunc(t *testing.T) {
+	h := newTestHandler()
+	h.ItemStatusFunc = func(action string, meta, doc map[string]any) int {
+		if _, ok := doc["@timestamp"]; !ok {
+			return http.StatusBadRequest
+		}
+		return 0
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	body := `{"index":{"_index":"logs"}}` + "\n" + `{"@timestamp":"2024-01-01T00:00:00Z"}` + "\n" +
+		`{"create":{"_index":"logs"}}` + "\n" + `{"message":"no timestamp"}` + "\n" +
+		`{"update":{"_index":"logs","_id":"1"}}` + "\n" + `{"@timestamp":"2024-01-01T00:00:00Z"}` + "\n"
+	status, reply := postBulk(t, srv.URL, "", strings.NewReader(body))
+	if status != http.StatusOK || reply["errors"] != true {
+		t.Fatalf("bulk returned %d %v, want 200 with errors", status, reply)
+	}
+	items, _ := reply["items"].([]any)
+	want := []struct {
+		action string
+		status float64
+	}{{"index", http.StatusOK}, {"created", http.StatusBadRequest}, {"update", http.StatusOK}}
+	if len(items) != len(want) {
+		t.Fatalf("bulk has items %v, want an item for every action", items)
+	}
+	for i, w := range want {
+		item, _ := items[i].(map[string]any)[w.action].(map[string]any)
+		if item["status"] != w.status {
+			t.Errorf("item %d is %v, want a %s item with status %v", i, items[i], w.action, w.status)
+		}
+	}
+	created, _ := items[1].(map[string]any)["created"].(map[string]any)
+	if errType := errorType(created); errType != "mapper_parsing_exception" {
+		t.Errorf("error type of the document without @timestamp is %q, want mapper_parsing_exception", errType)
+	}
+}
+
+// assertStored fails t unless every id is stored in index
+func assertStored(t *testing.T, h *APIHandler, index string, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if _, ok := h.Store.Get(index, id); !ok {
+			t.Errorf("document %s of %s is not stored", id, index)
+		}
+	}
+}
+
+// storedDocs returns the number and total size of the documents of h.Store
+func storedDocs(h *APIHandler) (int64, int64) {
+	docs, _ := h.Store.Count()
+	return docs, h.Store.Size()
+}
+
+func TestBulkLongLine(t *testing.T) {
+	h := newTestHandler()
+	h.Store = NewDocumentStore()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// a document line larger than the 64KB default of bufio.Scanner
+	doc := `{"message":"` + strings.Repeat("x", 100<<10) + `"}`
+	body := `{"index":{"_index":"logs","_id":"1"}}` + "\n" + doc + "\n" + `{"index":{"_index":"logs","_id":"2"}}` + "\n" + `{"a":1}` + "\n"
+	status, reply := postBulk(t, srv.URL, "", strings.NewReader(body))
+	if status != http.StatusOK || reply["errors"] != false {
+		t.Fatalf("bulk with a 100KB line returned %d %v", status, reply)
+	}
+	if docs, size := storedDocs(h); docs != 2 || size != int64(len(doc)+len(`{"a":1}`)) {
+		t.Errorf("stored %d documents of %d bytes, want both documents whole", docs, size)
+	}
+}
+
+func TestBulkLineOverMaxLineSize(t *testing.T) {
+	h := newTestHandler()
+	h.StrictBulk = true
+	h.MaxLineSize = 64 << 10
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	body := `{"index":{"_index":"logs"}}` + "\n" + `{"message":"` + strings.Repeat("x", 100<<10) + `"}` + "\n"
+	status, reply := postBulk(t, srv.URL, "", strings.NewReader(body))
+	if status != http.StatusBadRequest {
+		t.Fatalf("bulk with a line over max line size returned %d, want 400", status)
+	}
+	if errType := errorType(reply); errType != "illegal_argument_exception" {
+		t.Errorf("error type is %q, want illegal_argument_exception", errType)
+	}
+	e, _ := reply["error"].(map[string]any)
+	if reason, _ := e["reason"].(string); !strings.Contains(reason, "Failed to read line [2]") {
+		t.Errorf("error reason %q doesn't name line 2", reason)
+	}
+}
+
+func TestBulkLineOverMaxLineSizeSkipped(t *testing.T) {
+	h := newTestHandler()
+	h.Store = NewDocumentStore()
+	h.MaxLineSize = 64 << 10
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	body := `{"index":{"_index":"logs","_id":"1"}}` + "\n" + `{"a":1}` + "\n" + `{"index":{"_index":"logs","_id":"2"}}` + "\n" + `{"message":"` + strings.Repeat("x", 100<<10) + `"}` + "\n"
+	status, _ := postBulk(t, srv.URL, "", strings.NewReader(body))
+	if status != http.StatusOK {
+		t.Fatalf("bulk with a line over max line size returned %d, want 200", status)
+	}
+	if docs, _ := storedDocs(h); docs != 1 {
+		t.Errorf("stored %d documents, want the document before the line over max line size only", docs)
+	}
+}
+
+// readActions returns the actions of a bulk body read by a bulkReader
+func readActions(t *testing.T, body string, strict bool) []bulkAction {
+	t.Helper()
+	br := &bulkReader{scanner: bufio.NewScanner(strings.NewReader(body)), strict: strict, parseDocs: true}
+	var actions []bulkAction
+	for {
+		a, err := br.next()
+		if errors.Is(err, io.EOF) {
+			return actions
+		}
+		if err != nil {
+			t.Fatalf("error reading bulk body: %s", err)
+		}
+		actions = append(actions, *a)
+	}
+}
+
+func TestBulkCRLF(t *testing.T) {
+	lines := []string{
+		`{"index":{"_index":"logs","_id":"1"}}`,
+		`{"message":"hello"}`,
+		`{"create":{"_index":"logs","_id":"2"}}`,
+		`{"message":"world"}`,
+		`{"update":{"_index":"logs","_id":"1"}}`,
+		`{"doc":{"message":"bye"}}`,
+		`{"delete":{"_index":"logs","_id":"2"}}`,
+	}
+	lf := strings.Join(lines, "\n") + "\n"
+	crlf := strings.Join(lines, "\r\n") + "\r\n"
+	for _, strict := range []bool{false, true} {
+		want := readActions(t, lf, strict)
+		if len(want) != 4 {
+			t.Fatalf("strict %v: read %d actions from the \\n body, want 4", strict, len(want))
+		}
+		if got := readActions(t, crlf, strict); !reflect.DeepEqual(got, want) {
+			t.Errorf("strict %v: \\r\\n body actions are %+v, want %+v", strict, got, want)
+		}
+	}
+}
+
+func TestBulkCRLFRequest(t *testing.T) {
+	h := newTestHandler()
+	h.Store = NewDocumentStore()
+	h.StrictBulk = true
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	body := "{\"index\":{\"_index\":\"logs\",\"_id\":\"1\"}}\r\n{\"message\":\"hello\"}\r\n"
+	status, reply := postBulk(t, srv.URL, "", strings.NewReader(body))
+	if status != http.StatusOK || reply["errors"] != false {
+		t.Fatalf("strict bulk with \\r\\n line endings returned %d %v", status, reply)
+	}
+	if docs, size := storedDocs(h); docs != 1 || size != int64(len(`{"message":"hello"}`)) {
+		t.Errorf("stored %d documents of %d bytes, want the document without \\r", docs, size)
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	srv, h := NewTestServer(WithStore())
+	defer srv.Close()
+
+	body := `{"index":{"_index":"logs","_id":"1"}}` + "\n" + `{"a":1,"o":{"b":1,"c":2}}` + "\n" +
+		`{"update":{"_index":"logs","_id":"1"}}` + "\n" + `{"doc":{"d":3,"o":{"c":4}}}` + "\n" +
+		`{"update":{"_index":"logs","_id":"2"}}` + "\n" + `{"doc":{"d":3}}` + "\n" +
+		`{"update":{"_index":"logs","_id":"3"}}` + "\n" + `{"doc":{"d":3},"doc_as_upsert":true}` + "\n" +
+		`{"update":{"_index":"logs","_id":"4"}}` + "\n" + `{"doc":{"d":3},"upsert":{"e":5}}` + "\n"
+	status, reply := postBulk(t, srv.URL, "", strings.NewReader(body))
+	if status != http.StatusOK {
+		t.Fatalf("bulk returned %d", status)
+	}
+	items, _ := reply["items"].([]any)
+	if len(items) != 1 {
+		t.Fatalf("bulk has items %v, want the missing document only", items)
+	}
+	update, _ := items[0].(map[string]any)["update"].(map[string]any)
+	if update["status"] != float64(http.StatusNotFound) || errorType(update) != "document_missing_exception" {
+		t.Errorf("item of the missing document is %v", update)
+	}
+
+	for id, want := range map[string]string{
+		"1": `{"a":1,"d":3,"o":{"b":1,"c":4}}`,
+		"3": `{"d":3}`,
+		"4": `{"e":5}`,
+	} {
+		sd, ok := h.Store.Get("logs", id)
+		if !ok {
+			t.Errorf("document %s is not stored", id)
+			continue
+		}
+		if string(sd.Source) != want {
+			t.Errorf("document %s is %s, want %s", id, sd.Source, want)
+		}
+	}
+	if _, ok := h.Store.Get("logs", "2"); ok {
+		t.Errorf("the update of a missing document created it")
+	}
+}
+
+// chunked hides the length of a body, so it is sent chunked without a
+// Content-Length
+type chunked struct {
+	io.Reader
+}
+
+// counter returns the count of the counter name of registry
+func counter(registry metrics.Registry, name string) int64 {
+	c, _ := registry.Get(name).(metrics.Counter)
+	if c == nil {
+		return 0
+	}
+	return c.Count()
+}
+
+func TestBulkChunked(t *testing.T) {
+	registry := metrics.NewRegistry()
+	srv, h := NewTestServer(WithStore(), WithMetricsRegistry(registry))
+	defer srv.Close()
+
+	body := `{"index":{"_index":"logs","_id":"1"}}` + "\n" + `{"message":"hello"}` + "\n" + `{"create":{"_index":"logs","_id":"2"}}` + "\n" + `{"message":"world"}` + "\n"
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte(body))
+	gw.Close()
+
+	status, reply := postBulk(t, srv.URL, "", chunked{strings.NewReader(body)})
+	if status != http.StatusOK || reply["errors"] != false {
+		t.Fatalf("chunked bulk returned %d %v", status, reply)
+	}
+	assertStored(t, h, "logs", "1", "2")
+	if n := counter(registry, bulkBytesReceivedMetrics); n != int64(len(body)) {
+		t.Errorf("%s is %d, want %d", bulkBytesReceivedMetrics, n, len(body))
+	}
+	if n := counter(registry, bulkBytesDecodedMetrics); n != int64(len(body)) {
+		t.Errorf("%s is %d, want %d", bulkBytesDecodedMetrics, n, len(body))
+	}
+
+	status, reply = postBulk(t, srv.URL, "gzip", chunked{bytes.NewReader(gzipped.Bytes())})
+	if status != http.StatusOK {
+		t.Fatalf("chunked gzip bulk returned %d %v", status, reply)
+	}
+	if n := counter(registry, bulkBytesReceivedMetrics); n != int64(len(body)+gzipped.Len()) {
+		t.Errorf("%s is %d, want %d", bulkBytesReceivedMetrics, n, len(body)+gzipped.Len())
+	}
+	if n := counter(registry, bulkBytesDecodedMetrics); n != int64(2*len(body)) {
+		t.Errorf("%s is %d, want %d", bulkBytesDecodedMetrics, n, 2*len(body))
+	}
+}
+
+// testBulkBody is a bulk body of two documents, 1 and 2 in logs
+const testBulkBody = `{"index":{"_index":"logs","_id":"1"}}` + "\n" + `{"message":"hello"}` + "\n" + `{"create":{"_index":"logs","_id":"2"}}` + "\n" + `{"message":"world"}` + "\n"
+
+func TestBulkDeflate(t *testing.T) {
+	srv, h := NewTestServer(WithStore())
+	defer srv.Close()
+
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	zw.Write([]byte(testBulkBody))
+	zw.Close()
+	status, reply := postBulk(t, srv.URL, "deflate", &deflated)
+	if status != http.StatusOK || reply["errors"] != false {
+		t.Fatalf("deflate bulk returned %d %v", status, reply)
+	}
+	assertStored(t, h, "logs", "1", "2")
+}
+
+func TestBulkUnsupportedEncoding(t *testing.T) {
+	srv, h := NewTestServer(WithStore())
+	defer srv.Close()
+
+	status, reply := postBulk(t, srv.URL, "br", strings.NewReader(testBulkBody))
+	if status != http.StatusUnsupportedMediaType {
+		t.Fatalf("br bulk returned %d, want 415", status)
+	}
+	if errType := errorType(reply); errType != "illegal_argument_exception" {
+		t.Errorf("error type is %q, want illegal_argument_exception", errType)
+	}
+	if _, ok := h.Store.Get("logs", "1"); ok {
+		t.Errorf("an action of the bulk with an unsupported encoding was performed")
+	}
+}
+
+func TestBulkCorruptGzip(t *testing.T) {
+	srv, _ := NewTestServer(WithStore())
+	defer srv.Close()
+
+	status, reply := postBulk(t, srv.URL, "gzip", strings.NewReader(testBulkBody))
+	if status != http.StatusBadRequest {
+		t.Fatalf("corrupt gzip bulk returned %d, want 400", status)
+	}
+	if errType := errorType(reply); errType != "parse_exception" {
+		t.Errorf("error type is %q, want parse_exception", errType)
+	}
+}
+
+func TestBulkZstd(t *testing.T) {
+	srv, h := NewTestServer(WithStore())
+	defer srv.Close()
+
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("error creating zstd encoder: %s", err)
+	}
+	compressed := zw.EncodeAll([]byte(testBulkBody), nil)
+	zw.Close()
+	status, reply := postBulk(t, srv.URL, "zstd", bytes.NewReader(compressed))
+	if status != http.StatusOK || reply["errors"] != false {
+		t.Fatalf("zstd bulk returned %d %v", status, reply)
+	}
+	assertStored(t, h, "logs", "1", "2")
+	if sd, _ := h.Store.Get("logs", "2"); string(sd.Source) != `{"message":"world"}` {
+		t.Errorf("stored document is %q", sd.Source)
+	}
+}
+
+func TestItemStatusFuncMetaAndDoc(t *testing.T) {
+	srv, h := NewTestServer(WithStore(), WithItemStatusFunc(func(action string, meta, doc map[string]any) int {
+		if quantity, _ := doc["quantity"].(float64); meta["_index"] == "orders" && quantity <= 0 {
+			return http.StatusBadRequest
+		}
+		return 0
+	}))
+	defer srv.Close()
+
+	body := `{"index":{"_index":"orders","_id":"1"}}` + "\n" + `{"quantity":2}` + "\n" +
+		`{"index":{"_index":"orders","_id":"2"}}` + "\n" + `{"quantity":0}` + "\n" +
+		`{"index":{"_index":"logs","_id":"3"}}` + "\n" + `{"quantity":0}` + "\n"
+	status, reply := postBulk(t, srv.URL, "", strings.NewReader(body))
+	if status != http.StatusOK || reply["errors"] != true {
+		t.Fatalf("bulk returned %d %v, want 200 with errors", status, reply)
+	}
+	items, _ := reply["items"].([]any)
+	if len(items) != 3 {
+		t.Fatalf("bulk has items %v, want an item for every action", items)
+	}
+	for i, want := range []float64{http.StatusOK, http.StatusBadRequest, http.StatusOK} {
+		item, _ := items[i].(map[string]any)["index"].(map[string]any)
+		if item["status"] != want {
+			t.Errorf("item %d is %v, want status %v", i, items[i], want)
+		}
+	}
+	assertStored(t, h, "orders", "1")
+	assertStored(t, h, "logs", "3")
+	if _, ok := h.Store.Get("orders", "2"); ok {
+		t.Errorf("the document rejected by ItemStatusFunc was stored")
+	}
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeCat writes a _cat API response.  By default rows are written as
+// plain text columns, with the headers as first row if the v query
+// parameter is set.  With format=json the rows are written as a JSON array
+// of objects keyed by the headers.
+func writeCat(w http.ResponseWriter, r *http.Request, headers []string, rows [][]string) {
+	q := r.URL.Query()
+	if q.Get("format") == "json" {
+		objs := make([]map[string]string, 0, len(rows))
+		for _, row := range rows {
+			obj := make(map[string]string, len(headers))
+			for i, header := range headers {
+				obj[header] = row[i]
+			}
+			objs = append(objs, obj)
+		}
+		writeJSON(w, http.StatusOK, objs)
+		return
+	}
+
+	if q.Has("v") && q.Get("v") != "false" {
+		rows = append([][]string{headers}, rows...)
+	}
+	widths := make([]int, len(headers))
+	for _, row := range rows {
+		for i, col := range row {
+			widths[i] = max(widths[i], len(col))
+		}
+	}
+	var sb strings.Builder
+	for _, row := range rows {
+		for i, col := range row {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(col)
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-len(col)))
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "text/plain; charset=UTF-8")
+	w.Write([]byte(sb.String()))
+}
+
+// CatHealth handles /_cat/health get requests
+func (h *APIHandler) CatHealth(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(catHealthTotalMetrics, h.metricsRegistry)
+	h.stats.add("cat_health", 1)
+	now := time.Now()
+	shards, primaries := strconv.Itoa(h.Shards*(1+h.Replicas)), strconv.Itoa(h.Shards)
+	headers := []string{"epoch", "timestamp", "cluster", "status", "node.total", "node.data", "shards", "pri", "relo", "init", "unassign", "pending_tasks", "max_task_wait_time", "active_shards_percent"}
+	row := []string{strconv.FormatInt(now.Unix(), 10), now.Format("15:04:05"), h.ClusterName, h.HealthStatus, "1", "1", shards, primaries, "0", "0", "0", "0", "-", "100.0%"}
+	writeCat(w, r, headers, [][]string{row})
+}
+
+// catCountIndex returns the index of the /_cat/count, /_cat/count/{index}
+// and /{index}/_cat/count endpoints, which is empty for the first, and
+// whether p is one of them
+func catCountIndex(p string) (string, bool) {
+	if p == "/_cat/count" {
+		return "", true
+	}
+	if index, ok := strings.CutPrefix(p, "/_cat/count/"); ok {
+		return index, index != "" && !strings.Contains(index, "/")
+	}
+	if index, ok := strings.CutSuffix(p, "/_cat/count"); ok {
+		return strings.TrimPrefix(index, "/"), isIndexPath(index)
+	}
+	return "", false
+}
+
+// isCatCountPath returns true for the /_cat/count endpoints
+func isCatCountPath(p string) bool {
+	_, ok := catCountIndex(p)
+	return ok
+}
+
+// CatCount handles /_cat/count get requests by counting the stored
+// documents of the index, or of all indices
+func (h *APIHandler) CatCount(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(catCountTotalMetrics, h.metricsRegistry)
+	h.stats.add("cat_count", 1)
+	index, _ := catCountIndex(r.URL.Path)
+	if _, missing := h.resolveIndices(index); missing != "" {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", missing))
+		return
+	}
+	var count int64
+	if h.Store != nil {
+		count = h.Store.CountIndices(index)
+	}
+	now := time.Now()
+	headers := []string{"epoch", "timestamp", "count"}
+	row := []string{strconv.FormatInt(now.Unix(), 10), now.Format("15:04:05"), strconv.FormatInt(count, 10)}
+	writeCat(w, r, headers, [][]string{row})
+}
+
+// CatAliases handles /_cat/aliases get requests
+func (h *APIHandler) CatAliases(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(catAliasesTotalMetrics, h.metricsRegistry)
+	h.stats.add("cat_aliases", 1)
+	headers := []string{"alias", "index", "filter", "routing.index", "routing.search", "is_write_index"}
+	rows := [][]string{}
+	for _, ai := range h.aliases.all() {
+		isWriteIndex := "-"
+		if ai.IsWriteIndex != nil {
+			isWriteIndex = strconv.FormatBool(*ai.IsWriteIndex)
+		}
+		rows = append(rows, []string{ai.Alias, ai.Index, "-", "-", "-", isWriteIndex})
+	}
+	writeCat(w, r, headers, rows)
+}
+
+// CatTemplates handles /_cat/templates get requests, listing both the
+// composable and the legacy index templates
+func (h *APIHandler) CatTemplates(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(catTemplatesTotalMetrics, h.metricsRegistry)
+	h.stats.add("cat_templates", 1)
+	headers := []string{"name", "index_patterns", "order", "version", "composed_of"}
+	rows := [][]string{}
+	for _, name := range h.legacyTemplates.match("*") {
+		body, _ := h.legacyTemplates.get(name)
+		rows = append(rows, templateRow(name, body, false))
+	}
+	for _, name := range h.indexTemplates.match("*") {
+		body, _ := h.indexTemplates.get(name)
+		rows = append(rows, templateRow(name, body, true))
+	}
+	writeCat(w, r, headers, rows)
+}
+
+// templateRow returns the /_cat/templates row of the template with name
+// and body.  The order of a composable template is its priority.
+func templateRow(name string, body json.RawMessage, composable bool) []string {
+	var t struct {
+		IndexPatterns []string `json:"index_patterns"`
+		Order         *int64   `json:"order"`
+		Priority      *int64   `json:"priority"`
+		Version       *int64   `json:"version"`
+		ComposedOf    []string `json:"composed_of"`
+	}
+	if err := json.Unmarshal(body, &t); err != nil {
+		log.Printf("error unmarshal template %s: %s", name, err)
+	}
+	order := t.Order
+	if composable {
+		order = t.Priority
+	}
+	composedOf := ""
+	if composable {
+		composedOf = "[" + strings.Join(t.ComposedOf, ", ") + "]"
+	}
+	return []string{name, "[" + strings.Join(t.IndexPatterns, ", ") + "]", optionalInt(order), optionalInt(t.Version), composedOf}
+}
+
+// optionalInt formats i, or returns an empty string if i is nil
+func optionalInt(i *int64) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.FormatInt(*i, 10)
+}
+
+// formatBytes formats n bytes like the store sizes of the _cat APIs, eg
+// 225b or 4.5kb
+func formatBytes(n int64) string {
+	units := []string{"b", "kb", "mb", "gb", "tb"}
+	size, unit := float64(n), 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(math.Round(size*10)/10, 'f', -1, 64) + units[unit]
+}
+
+// CatShards handles /_cat/shards and /_cat/shards/{index} get requests,
+// listing every primary and replica shard of the matching indices and data
+// stream backing indices.  All shards are started, the replicas on other
+// nodes, and the documents of the Store are counted in the shards they are
+// routed to by _id.
+func (h *APIHandler) CatShards(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(catShardsTotalMetrics, h.metricsRegistry)
+	h.stats.add("cat_shards", 1)
+	_, index, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/_cat/shards"), "/")
+	indices, missing := h.resolveIndices(index)
+	if missing != "" {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", missing))
+		return
+	}
+	headers := []string{"index", "shard", "prirep", "state", "docs", "store", "ip", "node"}
+	rows := [][]string{}
+	for _, mi := range indices {
+		docs, sizes := make([]int64, h.Shards), make([]int64, h.Shards)
+		if h.Store != nil {
+			stored, _ := h.Store.Search(mi.index, 0, math.MaxInt)
+			for _, sd := range stored {
+				shard := h.shardFor(map[string]any{"_id": sd.ID})
+				docs[shard]++
+				sizes[shard] += int64(len(sd.Source))
+			}
+		}
+		for shard := 0; shard < h.Shards; shard++ {
+			for replica := 0; replica <= h.Replicas; replica++ {
+				prirep, node := "p", nodeName
+				if replica > 0 {
+					prirep, node = "r", fmt.Sprintf("%s-%d", nodeName, replica)
+				}
+				rows = append(rows, []string{mi.index, strconv.Itoa(shard), prirep, "STARTED", strconv.FormatInt(docs[shard], 10), formatBytes(sizes[shard]), "127.0.0.1", node})
+			}
+		}
+	}
+	writeCat(w, r, headers, rows)
+}
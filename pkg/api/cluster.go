@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// ClusterStats handles /_cluster/stats get requests.  The document count is
+// taken from the document store when there is one, otherwise from the
+// number of successful index and create actions.
+func (h *APIHandler) ClusterStats(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(clusterStatsTotalMetrics, h.metricsRegistry)
+	h.stats.add("cluster_stats", 1)
+	var docs int64
+	var indices int
+	if h.Store != nil {
+		docs, indices = h.Store.Count()
+	} else {
+		docs = metrics.GetOrRegisterCounter(bulkIndexTotalMetrics, h.metricsRegistry).Count() +
+			metrics.GetOrRegisterCounter(bulkCreateOkMetrics, h.metricsRegistry).Count()
+	}
+	stats := map[string]any{
+		"_nodes":       map[string]any{"total": 1, "successful": 1, "failed": 0},
+		"cluster_name": h.ClusterName,
+		"cluster_uuid": h.ClusterUUID,
+		"timestamp":    time.Now().UnixMilli(),
+		"status":       h.HealthStatus,
+		"indices": map[string]any{
+			"count": indices,
+			"docs":  map[string]any{"count": docs, "deleted": 0},
+		},
+		"nodes": map[string]any{
+			"count": map[string]any{"total": 1, "data": 1, "master": 1, "ingest": 1},
+		},
+	}
+	writeJSON(w, http.StatusOK, stats)
+	return
+}
+
+// ClusterHealth handles /_cluster/health get requests
+func (h *APIHandler) ClusterHealth(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(clusterHealthTotalMetrics, h.metricsRegistry)
+	h.stats.add("cluster_health", 1)
+	health := map[string]any{
+		"cluster_name":                     h.ClusterName,
+		"status":                           h.HealthStatus,
+		"timed_out":                        false,
+		"number_of_nodes":                  1,
+		"number_of_data_nodes":             1,
+		"active_primary_shards":            h.Shards,
+		"active_shards":                    h.Shards * (1 + h.Replicas),
+		"relocating_shards":                0,
+		"initializing_shards":              0,
+		"unassigned_shards":                0,
+		"delayed_unassigned_shards":        0,
+		"number_of_pending_tasks":          0,
+		"number_of_in_flight_fetch":        0,
+		"task_max_waiting_in_queue_millis": 0,
+		"active_shards_percent_as_number":  100.0,
+	}
+	writeJSON(w, http.StatusOK, health)
+	return
+}
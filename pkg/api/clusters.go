@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// VirtualClusters serves several clusters from one server, each with its
+// own APIHandler, chosen by the Host header or by a path prefix.  Requests
+// that match no cluster are served by the default handler.
+type VirtualClusters struct {
+	def      http.Handler
+	hosts    map[string]http.Handler
+	prefixes []string
+	byPrefix map[string]http.Handler
+}
+
+// NewVirtualClusters returns VirtualClusters serving the requests that
+// match no cluster with def
+func NewVirtualClusters(def http.Handler) *VirtualClusters {
+	return &VirtualClusters{def: def, hosts: make(map[string]http.Handler), byPrefix: make(map[string]http.Handler)}
+}
+
+// Handle serves the requests matching key with h.  A key starting with / is
+// a path prefix, eg /cluster2, which is removed from the path of the
+// requests before h serves them.  Any other key is a host, eg
+// cluster2.local, or a host and port, eg cluster2.local:9200, matched
+// against the Host header.  Handle is not safe for concurrent use with
+// ServeHTTP, clusters are meant to be set up before serving.
+func (vc *VirtualClusters) Handle(key string, h http.Handler) {
+	if !strings.HasPrefix(key, "/") {
+		vc.hosts[strings.ToLower(key)] = h
+		return
+	}
+	prefix := strings.TrimSuffix(key, "/")
+	if _, ok := vc.byPrefix[prefix]; !ok {
+		vc.prefixes = append(vc.prefixes, prefix)
+		// the longest prefix is matched first
+		sort.Slice(vc.prefixes, func(i, j int) bool { return len(vc.prefixes[i]) > len(vc.prefixes[j]) })
+	}
+	vc.byPrefix[prefix] = h
+}
+
+// ServeHTTP serves r with the handler of the cluster matching its Host
+// header, then with the one of the longest matching path prefix, or with
+// the default handler
+func (vc *VirtualClusters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := strings.ToLower(r.Host)
+	if h, ok := vc.hosts[host]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		if h, ok := vc.hosts[hostname]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	for _, prefix := range vc.prefixes {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			continue
+		}
+		if rest == "" {
+			rest = "/"
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = rest
+		r2.URL.RawPath = ""
+		vc.byPrefix[prefix].ServeHTTP(w, r2)
+		return
+	}
+	vc.def.ServeHTTP(w, r)
+}
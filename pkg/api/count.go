@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// CountResponse is the reply to /_count
+type CountResponse struct {
+	Count  int64      `json:"count"`
+	Shards ShardsInfo `json:"_shards"`
+}
+
+// Count handles /_count and /{index}/_count get and post requests by
+// counting the stored documents of the index, or of all indices.  Every
+// query is treated as match_all, and without a Store the count is 0.  An
+// index without wildcards that doesn't exist is not found.
+func (h *APIHandler) Count(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(countTotalMetrics, h.metricsRegistry)
+	h.stats.add("count", 1)
+	index, _ := indexAPIPath(r.URL.Path, "_count")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("error reading count body: %s", err)
+		return
+	}
+	if len(body) > 0 {
+		var query map[string]any
+		if err := json.Unmarshal(body, &query); err != nil {
+			h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+			return
+		}
+	}
+	if _, missing := h.resolveIndices(index); missing != "" {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", missing))
+		return
+	}
+	cr := CountResponse{Shards: h.shardsInfo(index)}
+	if h.Store != nil {
+		cr.Count = h.Store.CountIndices(index)
+	}
+	writeJSON(w, http.StatusOK, cr)
+	return
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// dataStreamPath is the path of the data stream endpoints
+const dataStreamPath = "/_data_stream"
+
+// DataStream is a data stream in a get response
+type DataStream struct {
+	Name           string            `json:"name"`
+	TimestampField DataStreamField   `json:"timestamp_field"`
+	Indices        []DataStreamIndex `json:"indices"`
+	Generation     int               `json:"generation"`
+	Status         string            `json:"status"`
+	Hidden         bool              `json:"hidden"`
+	System         bool              `json:"system"`
+}
+
+// DataStreamField is the timestamp field of a data stream
+type DataStreamField struct {
+	Name string `json:"name"`
+}
+
+// DataStreamIndex is a backing index of a data stream
+type DataStreamIndex struct {
+	IndexName string `json:"index_name"`
+	IndexUUID string `json:"index_uuid"`
+}
+
+// dataStreams keeps the created data streams.  The zero value is empty and
+// ready to use.  It is safe for concurrent use.
+type dataStreams struct {
+	mu      sync.RWMutex
+	streams map[string]*DataStream
+}
+
+// create adds a data stream with name and its first backing index,
+// returning false if it already exists
+func (d *dataStreams) create(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.streams[name]; ok {
+		return false
+	}
+	if d.streams == nil {
+		d.streams = make(map[string]*DataStream)
+	}
+	d.streams[name] = &DataStream{
+		Name:           name,
+		TimestampField: DataStreamField{Name: "@timestamp"},
+		Indices:        []DataStreamIndex{backingIndex(name, 1)},
+		Generation:     1,
+		Status:         "GREEN",
+	}
+	return true
+}
+
+// rollover adds a backing index to data stream name, returning the names
+// of the previous and of the new write index.  With dryRun the data stream
+// is not changed.
+func (d *dataStreams) rollover(name string, dryRun bool) (oldIndex, newIndex string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ds := d.streams[name]
+	next := backingIndex(name, ds.Generation+1)
+	oldIndex = ds.Indices[len(ds.Indices)-1].IndexName
+	if !dryRun {
+		ds.Generation++
+		ds.Indices = append(ds.Indices, next)
+	}
+	return oldIndex, next.IndexName
+}
+
+// exists returns true if there is a data stream with name
+func (d *dataStreams) exists(name string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	_, ok := d.streams[name]
+	return ok
+}
+
+// match returns copies of the data streams matching a comma separated list
+// of names with * wildcards, sorted by name
+func (d *dataStreams) match(names string) []DataStream {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var matched []DataStream
+	for name, ds := range d.streams {
+		for _, pattern := range strings.Split(names, ",") {
+			if ok, _ := path.Match(pattern, name); ok {
+				c := *ds
+				c.Indices = append([]DataStreamIndex(nil), ds.Indices...)
+				matched = append(matched, c)
+				break
+			}
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	return matched
+}
+
+// backingIndex returns backing index number generation of data stream name
+func backingIndex(name string, generation int) DataStreamIndex {
+	return DataStreamIndex{
+		IndexName: fmt.Sprintf(".ds-%s-%s-%06d", name, time.Now().UTC().Format("2006.01.02"), generation),
+		IndexUUID: uuid.NewString(),
+	}
+}
+
+// DataStream handles /_data_stream/{name} put requests by creating the data
+// stream, and /_data_stream and /_data_stream/{name} get requests by
+// returning the data streams matching name
+func (h *APIHandler) DataStream(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(dataStreamTotalMetrics, h.metricsRegistry)
+	h.stats.add("data_stream", 1)
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, dataStreamPath), "/")
+	if r.Method == http.MethodPut {
+		if !h.dataStreams.create(name) {
+			h.writeError(w, http.StatusBadRequest, "resource_already_exists_exception", fmt.Sprintf("data_stream [%s] already exists", name))
+			return
+		}
+		writeAcknowledged(w)
+		return
+	}
+
+	if name == "" {
+		name = "*"
+	}
+	streams := h.dataStreams.match(name)
+	if len(streams) == 0 && !strings.Contains(name, "*") {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", name))
+		return
+	}
+	if streams == nil {
+		streams = []DataStream{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data_streams": streams})
+	return
+}
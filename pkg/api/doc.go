@@ -0,0 +1,235 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DocumentResponse is the reply to /{index}/_doc/{id} requests
+type DocumentResponse struct {
+	Index       string          `json:"_index"`
+	ID          string          `json:"_id"`
+	Version     int64           `json:"_version,omitempty"`
+	SeqNo       *int64          `json:"_seq_no,omitempty"`
+	PrimaryTerm int64           `json:"_primary_term,omitempty"`
+	Found       *bool           `json:"found,omitempty"`
+	Result      string          `json:"result,omitempty"`
+	Shards      *ShardsInfo     `json:"_shards,omitempty"`
+	Source      json.RawMessage `json:"_source,omitempty"`
+}
+
+// docPath returns the index and id of the /{index}/_doc/{id} endpoints,
+// and of the /{index}/_doc endpoint whose id is empty, and whether p is one
+// of them
+func docPath(p string) (index, id string, ok bool) {
+	if index, ok := indexAPIPath(p, "_doc"); ok && index != "" {
+		return index, "", true
+	}
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if len(parts) != 3 || parts[1] != "_doc" || parts[2] == "" || !isIndexPath("/"+parts[0]) {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
+
+// isDocPath returns true for the /{index}/_doc/{id} endpoints
+func isDocPath(p string) bool {
+	_, id, ok := docPath(p)
+	return ok && id != ""
+}
+
+// isNewDocPath returns true for the /{index}/_doc endpoints, which index
+// a document with a generated id
+func isNewDocPath(p string) bool {
+	_, id, ok := docPath(p)
+	return ok && id == ""
+}
+
+// Doc handles /{index}/_doc/{id} get requests by returning the stored
+// document, filtered by the _source, _source_includes and _source_excludes
+// query parameters, head requests by replying StatusOK if it exists and
+// StatusNotFound otherwise, put and post requests by indexing the body,
+// and delete requests by removing it.  The documents are those of the bulk
+// requests, they are only found with a Store.  Post requests to
+// /{index}/_doc index the body with a generated id.
+func (h *APIHandler) Doc(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(docTotalMetrics, h.metricsRegistry)
+	h.stats.add("doc", 1)
+	index, id, _ := docPath(r.URL.Path)
+	if r.Method == http.MethodPut || r.Method == http.MethodPost {
+		h.indexDoc(w, r, index, id)
+		return
+	}
+	if !h.indexExists(index) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", index))
+		return
+	}
+	if r.Method == http.MethodDelete {
+		h.deleteDoc(w, r, index, id)
+		return
+	}
+	var sd StoredDocument
+	found := false
+	if h.Store != nil {
+		sd, found = h.Store.Get(index, id)
+	}
+	dr := DocumentResponse{Index: index, ID: id, Found: &found}
+	status := http.StatusNotFound
+	if found {
+		status = http.StatusOK
+		dr.Version, dr.SeqNo, dr.PrimaryTerm = sd.Version, &sd.SeqNo, 1
+		source, err := filterSourceParams(r, sd.Source)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+			return
+		}
+		dr.Source = source
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, dr)
+}
+
+// indexDoc replies to the put or post request r of the document with id,
+// or a generated id if it is empty, in index.  The document is stored like
+// the ones of bulk index actions, with op_type=create it must not exist.
+func (h *APIHandler) indexDoc(w http.ResponseWriter, r *http.Request, index, id string) {
+	incrementCounter(docIndexMetrics, h.metricsRegistry)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("error reading doc body: %s", err)
+		return
+	}
+	var source map[string]any
+	if err := json.Unmarshal(body, &source); err != nil {
+		h.writeError(w, http.StatusBadRequest, "mapper_parsing_exception", fmt.Sprintf("failed to parse: %s", err))
+		return
+	}
+	a := &bulkAction{action: "index", index: index, id: id, doc: body}
+	if h.autoCreateDenied(a) {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", index))
+		return
+	}
+	if r.URL.Query().Get("op_type") == "create" && id != "" && h.Store != nil {
+		if sd, ok := h.Store.Get(index, id); ok {
+			h.writeError(w, http.StatusConflict, "version_conflict_engine_exception", fmt.Sprintf("[%s]: version conflict, document already exists (current version [%d])", id, sd.Version))
+			return
+		}
+	}
+	sd, created := h.storeDocument(a)
+	dr := DocumentResponse{Index: index, ID: sd.ID, Version: sd.Version, Result: "updated", Shards: h.docShardsInfo(index, sd.ID), SeqNo: &sd.SeqNo, PrimaryTerm: 1}
+	status := http.StatusOK
+	if created {
+		dr.Result = "created"
+		status = http.StatusCreated
+	}
+	if !h.waitForRefresh(r) {
+		return
+	}
+	writeJSON(w, status, dr)
+}
+
+// deleteDoc replies to the delete request r of the document with id in
+// index, removing it from the Store
+func (h *APIHandler) deleteDoc(w http.ResponseWriter, r *http.Request, index, id string) {
+	incrementCounter(docDeleteMetrics, h.metricsRegistry)
+	var sd StoredDocument
+	deleted := false
+	if h.Store != nil {
+		sd, deleted = h.Store.Remove(index, id)
+	}
+	dr := DocumentResponse{Index: index, ID: id, Version: 1, Result: "not_found", Shards: h.docShardsInfo(index, id), SeqNo: &sd.SeqNo, PrimaryTerm: 1}
+	status := http.StatusNotFound
+	if deleted {
+		dr.Version, dr.Result = sd.Version, "deleted"
+		status = http.StatusOK
+	}
+	if !h.waitForRefresh(r) {
+		return
+	}
+	writeJSON(w, status, dr)
+}
+
+// filterSourceParams returns doc filtered by the source filtering query
+// parameters of r.  _source is false for no source, true for the whole
+// source, or a comma separated list of fields to include.
+// _source_includes and _source_excludes are comma separated lists of
+// fields, which can contain * wildcards.  A nil source is omitted.
+func filterSourceParams(r *http.Request, doc []byte) (json.RawMessage, error) {
+	q := r.URL.Query()
+	var includes, excludes []string
+	switch source := q.Get("_source"); source {
+	case "false":
+		return nil, nil
+	case "", "true":
+	default:
+		includes = strings.Split(source, ",")
+	}
+	if v := q.Get("_source_includes"); v != "" {
+		includes = strings.Split(v, ",")
+	}
+	if v := q.Get("_source_excludes"); v != "" {
+		excludes = strings.Split(v, ",")
+	}
+	if len(includes) == 0 && len(excludes) == 0 {
+		return doc, nil
+	}
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	var source map[string]any
+	if err := d.Decode(&source); err != nil {
+		return nil, fmt.Errorf("failed to parse source to filter it: %w", err)
+	}
+	return json.Marshal(filterSource(source, "", includes, excludes))
+}
+
+// filterSource returns the fields of source, whose paths start with prefix,
+// matching includes, or all of them if includes is empty, and not matching
+// excludes.  Fields of objects are matched by their dotted path, eg a.b.
+func filterSource(source map[string]any, prefix string, includes, excludes []string) map[string]any {
+	filtered := make(map[string]any)
+	for field, v := range source {
+		p := prefix + field
+		if matchAnyPath(excludes, p) {
+			continue
+		}
+		obj, isObj := v.(map[string]any)
+		if len(includes) == 0 || matchAnyPath(includes, p) {
+			if isObj && len(excludes) != 0 {
+				v = filterSource(obj, p+".", nil, excludes)
+			}
+			filtered[field] = v
+			continue
+		}
+		// an include like a.b matches a field of the object a
+		if isObj {
+			if f := filterSource(obj, p+".", includes, excludes); len(f) != 0 {
+				filtered[field] = f
+			}
+		}
+	}
+	return filtered
+}
+
+// matchAnyPath returns true if the dotted field path p matches one of
+// patterns
+func matchAnyPath(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
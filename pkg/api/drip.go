@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// dripChunkSize is the number of bytes dripWriter writes at once
+const dripChunkSize = 16
+
+// dripWriter trickles the response body, writing and flushing it
+// dripChunkSize bytes at a time with delay between the chunks
+type dripWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+	rc    *http.ResponseController
+}
+
+func (dw *dripWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if written > 0 {
+			time.Sleep(dw.delay)
+		}
+		chunk := b[:min(dripChunkSize, len(b))]
+		n, err := dw.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := dw.rc.Flush(); err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Flush implements http.Flusher if the wrapped ResponseWriter does
+func (dw *dripWriter) Flush() {
+	dw.rc.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter
+func (dw *dripWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+)
+
+// PathFault is an error returned for the requests to a path, with a
+// percent chance
+type PathFault struct {
+	// Status of the response
+	Status int `json:"status"`
+	// Percent chance a request fails, 0 is never and nil is every request
+	Percent *uint `json:"percent"`
+	// Body of the response, empty string is an Elasticsearch error
+	Body string `json:"body"`
+}
+
+// pathFault returns the PathFault of path p.  Faults are keyed by exact
+// path, or by a pattern with * wildcards like /*/_bulk.  An exact path
+// takes precedence, then patterns are tried in sorted order.
+func (h *APIHandler) pathFault(p string) (PathFault, bool) {
+	if pf, ok := h.PathFaults[p]; ok {
+		return pf, true
+	}
+	patterns := make([]string, 0, len(h.PathFaults))
+	for pattern := range h.PathFaults {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return h.PathFaults[pattern], true
+		}
+	}
+	return PathFault{}, false
+}
+
+// injectPathFault writes the response of the PathFault of r with its odds.
+// It returns true if it did, then the request must not be handled any
+// further.
+func (h *APIHandler) injectPathFault(w http.ResponseWriter, r *http.Request) bool {
+	if len(h.PathFaults) == 0 {
+		return false
+	}
+	pf, ok := h.pathFault(r.URL.Path)
+	if !ok || (pf.Percent != nil && uint(rand.Intn(100)) >= *pf.Percent) {
+		return false
+	}
+	incrementCounter(pathFaultMetrics, h.metricsRegistry)
+	if pf.Body == "" {
+		h.writeError(w, pf.Status, methodErrorType(pf.Status), http.StatusText(pf.Status))
+		return true
+	}
+	if json.Valid([]byte(pf.Body)) {
+		w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
+	}
+	w.WriteHeader(pf.Status)
+	w.Write([]byte(pf.Body))
+	return true
+}
+
+// injectConnectionFault hangs or resets the connection of r with the odds
+// set by HangPercent and ResetPercent.  A hung request is aborted once the
+// client gives up or StopHanging is called.  It returns true if the
+// connection was reset, then the request must not be handled any further.
+func (h *APIHandler) injectConnectionFault(w http.ResponseWriter, r *http.Request) bool {
+	if h.HangPercent == 0 && h.ResetPercent == 0 {
+		return false
+	}
+	n := uint(rand.Intn(100))
+	switch {
+	case n < h.HangPercent:
+		incrementCounter(connectionHangMetrics, h.metricsRegistry)
+		select {
+		case <-r.Context().Done():
+		case <-h.hangDone:
+		}
+		// the connection is closed rather than answered, so a hung
+		// request never looks successful to the client
+		panic(http.ErrAbortHandler)
+	case n < h.HangPercent+h.ResetPercent:
+		incrementCounter(connectionResetMetrics, h.metricsRegistry)
+		resetConnection(w)
+		return true
+	}
+	return false
+}
+
+// resetConnection closes the connection of w without a response.  TCP
+// connections are closed with a RST rather than a FIN.  Connections that
+// can't be hijacked, like HTTP/2 streams, are aborted instead.
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if err := tc.SetLinger(0); err != nil {
+			log.Printf("error setting linger: %s", err)
+		}
+	}
+	conn.Close()
+}
+
+// StopHanging releases the requests hung by HangPercent, and makes later
+// ones return at once.  It is meant to be called on server shutdown, eg
+// with http.Server.RegisterOnShutdown.
+func (h *APIHandler) StopHanging() {
+	h.stopHanging.Do(func() { close(h.hangDone) })
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// filterPath is a parsed filter_path query parameter, the dotted paths of
+// the fields to include and of those to exclude, prefixed with -
+type filterPath struct {
+	includes [][]string
+	excludes [][]string
+}
+
+// parseFilterPath parses a comma separated list of dotted paths, whose
+// segments can contain * wildcards or be ** to match any number of
+// segments
+func parseFilterPath(s string) filterPath {
+	var fp filterPath
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if exclude, ok := strings.CutPrefix(p, "-"); ok {
+			fp.excludes = append(fp.excludes, strings.Split(exclude, "."))
+		} else if p != "" {
+			fp.includes = append(fp.includes, strings.Split(p, "."))
+		}
+	}
+	return fp
+}
+
+// nextPaths returns what remains of paths to match under the field key
+func nextPaths(paths [][]string, key string) [][]string {
+	var next [][]string
+	for _, p := range paths {
+		if len(p) == 0 {
+			continue
+		}
+		if p[0] == "**" {
+			next = append(next, p)
+			next = append(next, nextPaths([][]string{p[1:]}, key)...)
+			continue
+		}
+		if ok, _ := path.Match(p[0], key); ok {
+			next = append(next, p[1:])
+		}
+	}
+	return next
+}
+
+// matchedPath returns true if one of paths is fully matched
+func matchedPath(paths [][]string) bool {
+	for _, p := range paths {
+		if len(p) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// includePaths returns the fields of v matching paths, and false if none
+// do.  Arrays don't consume path segments, like in Elasticsearch.
+func includePaths(v any, paths [][]string) (any, bool) {
+	if matchedPath(paths) {
+		return v, true
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		included := make(map[string]any)
+		for key, value := range v {
+			if next := nextPaths(paths, key); len(next) > 0 {
+				if value, ok := includePaths(value, next); ok {
+					included[key] = value
+				}
+			}
+		}
+		return included, len(included) > 0
+	case []any:
+		included := []any{}
+		for _, value := range v {
+			if value, ok := includePaths(value, paths); ok {
+				included = append(included, value)
+			}
+		}
+		return included, len(included) > 0
+	}
+	return nil, false
+}
+
+// excludePaths returns v without the fields matching paths, and false if
+// v itself matches
+func excludePaths(v any, paths [][]string) (any, bool) {
+	if matchedPath(paths) {
+		return nil, false
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		kept := make(map[string]any)
+		for key, value := range v {
+			if next := nextPaths(paths, key); len(next) > 0 {
+				if value, ok := excludePaths(value, next); ok {
+					kept[key] = value
+				}
+				continue
+			}
+			kept[key] = value
+		}
+		return kept, true
+	case []any:
+		kept := []any{}
+		for _, value := range v {
+			if value, ok := excludePaths(value, paths); ok {
+				kept = append(kept, value)
+			}
+		}
+		return kept, true
+	}
+	return v, true
+}
+
+// filter returns the JSON body b pruned to the included fields without the
+// excluded ones, or b unchanged if it is not JSON
+func (fp filterPath) filter(b []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return b
+	}
+	empty := []byte("{}")
+	if _, ok := v.([]any); ok {
+		empty = []byte("[]")
+	}
+	ok := true
+	if len(fp.includes) > 0 {
+		v, ok = includePaths(v, fp.includes)
+	}
+	if ok && len(fp.excludes) > 0 {
+		v, ok = excludePaths(v, fp.excludes)
+	}
+	if !ok {
+		return empty
+	}
+	filtered, err := json.Marshal(v)
+	if err != nil {
+		return b
+	}
+	return filtered
+}
+
+// formatWriter buffers a response to format its JSON body once the handler
+// is done, filtering it with filter_path if set and indenting it with two
+// spaces if pretty.  Flushes are ignored, so streamed responses are sent at
+// once.
+type formatWriter struct {
+	http.ResponseWriter
+	fp     *filterPath
+	pretty bool
+	status int
+	body   bytes.Buffer
+}
+
+func (fw *formatWriter) WriteHeader(status int) {
+	if fw.status == 0 {
+		fw.status = status
+	}
+}
+
+func (fw *formatWriter) Write(b []byte) (int, error) {
+	return fw.body.Write(b)
+}
+
+// Flush implements http.Flusher, the response is written by finish
+func (fw *formatWriter) Flush() {}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter
+func (fw *formatWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// finish writes the buffered response, with its body formatted if it is
+// JSON
+func (fw *formatWriter) finish() {
+	if fw.status == 0 && fw.body.Len() == 0 {
+		return
+	}
+	body := fw.body.Bytes()
+	if strings.Contains(fw.Header().Get("Content-Type"), "json") {
+		if fw.fp != nil {
+			body = fw.fp.filter(body)
+		}
+		var indented bytes.Buffer
+		if fw.pretty && json.Indent(&indented, body, "", "  ") == nil {
+			indented.WriteByte('\n')
+			body = indented.Bytes()
+		}
+		if fw.Header().Get("Content-Length") != "" {
+			fw.Header().Set(http.CanonicalHeaderKey("Content-Length"), strconv.Itoa(len(body)))
+		}
+	}
+	if fw.status != 0 {
+		fw.ResponseWriter.WriteHeader(fw.status)
+	}
+	fw.ResponseWriter.Write(body)
+}
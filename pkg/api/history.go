@@ -0,0 +1,324 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// RequestRecord is the history entry for a single request
+type RequestRecord struct {
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	UserAgent  string      `json:"user_agent,omitempty"`
+	OpaqueID   string      `json:"opaque_id,omitempty"`
+	Status     int         `json:"status"`
+	ClientCert string      `json:"client_cert,omitempty"`
+	Bulk       *BulkParams `json:"bulk,omitempty"`
+}
+
+// BulkParams are the query parameters of a bulk request
+type BulkParams struct {
+	Refresh      string `json:"refresh,omitempty"`
+	Pipeline     string `json:"pipeline,omitempty"`
+	RequireAlias bool   `json:"require_alias,omitempty"`
+}
+
+// RequestHistory keeps the most recent requests handled, in memory or in a
+// file.  It is safe for concurrent use.
+type RequestHistory struct {
+	mu      sync.Mutex
+	records []RequestRecord
+	cap     int
+	// the file of a file history, its size and the size at which it is
+	// rotated, 0 is never
+	file    *os.File
+	size    int64
+	maxSize int64
+}
+
+// NewRequestHistory returns a RequestHistory that keeps at most cap records,
+// a cap of 0 keeps every record
+func NewRequestHistory(cap int) *RequestHistory {
+	return &RequestHistory{cap: cap}
+}
+
+// NewFileRequestHistory returns a RequestHistory that appends the records
+// to the file at path as JSON lines, so they don't take memory.  When the
+// file grows over maxSize bytes it is renamed to path.1, replacing the
+// previous one, and a new file is started.  A maxSize of 0 never rotates
+// the file.
+func NewFileRequestHistory(path string, maxSize int64) (*RequestHistory, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open failed: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat failed: %w", err)
+	}
+	return &RequestHistory{file: f, size: fi.Size(), maxSize: maxSize}, nil
+}
+
+// Add appends rec, dropping the oldest record when the history is full
+func (rh *RequestHistory) Add(rec RequestRecord) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	if rh.file != nil {
+		rh.appendFile(rec)
+		return
+	}
+	if rh.cap > 0 && len(rh.records) >= rh.cap {
+		rh.records = rh.records[1:]
+	}
+	rh.records = append(rh.records, rec)
+}
+
+// appendFile writes rec to the file, rotating it when it is too large
+func (rh *RequestHistory) appendFile(rec RequestRecord) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("error marshal history record: %s", err)
+		return
+	}
+	line = append(line, '\n')
+	if rh.maxSize > 0 && rh.size > 0 && rh.size+int64(len(line)) > rh.maxSize {
+		if err := rh.rotate(); err != nil {
+			log.Printf("error rotating history file: %s", err)
+		}
+	}
+	n, err := rh.file.Write(line)
+	rh.size += int64(n)
+	if err != nil {
+		log.Printf("error writing history file: %s", err)
+	}
+}
+
+// rotate renames the file to name.1 and starts a new file.  If the file
+// can't be renamed it is reopened, so records keep being appended to it.
+func (rh *RequestHistory) rotate() error {
+	name := rh.file.Name()
+	if err := rh.file.Close(); err != nil {
+		return fmt.Errorf("close failed: %w", err)
+	}
+	renameErr := os.Rename(name, name+".1")
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if renameErr != nil {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(name, flag, 0o644)
+	if err != nil {
+		return fmt.Errorf("open failed: %w", err)
+	}
+	rh.file = f
+	if renameErr != nil {
+		return fmt.Errorf("rename failed: %w", renameErr)
+	}
+	rh.size = 0
+	return nil
+}
+
+// historyFile is a history file opened for reading, up to the size it had
+// when it was opened
+type historyFile struct {
+	file *os.File
+	size int64
+}
+
+// openFiles opens the history files oldest first, the rotated one first if
+// there is one, so they can be read without holding the lock.  Open files
+// keep their content when the history is rotated.  rh.mu must be held.
+func (rh *RequestHistory) openFiles() []historyFile {
+	name := rh.file.Name()
+	var files []historyFile
+	if f, err := os.Open(name + ".1"); err == nil {
+		if fi, err := f.Stat(); err == nil {
+			files = append(files, historyFile{file: f, size: fi.Size()})
+		} else {
+			log.Printf("error reading rotated history file: %s", err)
+			f.Close()
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("error opening rotated history file: %s", err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		log.Printf("error opening history file: %s", err)
+		return files
+	}
+	return append(files, historyFile{file: f, size: rh.size})
+}
+
+// Records returns a copy of the records, oldest first.  The records of a
+// file history are read from its files.
+func (rh *RequestHistory) Records() []RequestRecord {
+	rh.mu.Lock()
+	if rh.file == nil {
+		defer rh.mu.Unlock()
+		return append([]RequestRecord{}, rh.records...)
+	}
+	files := rh.openFiles()
+	rh.mu.Unlock()
+	records := []RequestRecord{}
+	eachLine(files, func(line []byte) {
+		var rec RequestRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			log.Printf("error unmarshal history record: %s", err)
+			return
+		}
+		records = append(records, rec)
+	})
+	return records
+}
+
+// eachLine calls f with each line of files, up to their size, and closes
+// them
+func eachLine(files []historyFile, f func(line []byte)) {
+	for _, hf := range files {
+		scanner := bufio.NewScanner(io.LimitReader(hf.file, hf.size))
+		scanner.Buffer(nil, DefaultMaxLineSize)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) > 0 {
+				f(scanner.Bytes())
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("error reading history file: %s", err)
+		}
+		hf.file.Close()
+	}
+}
+
+// WriteJSON writes the records as a JSON array, oldest first.  The records
+// of a file history are copied from its files without being held in memory,
+// and without blocking the requests recorded meanwhile, which are not
+// written.
+func (rh *RequestHistory) WriteJSON(w io.Writer) error {
+	rh.mu.Lock()
+	if rh.file == nil {
+		rh.mu.Unlock()
+		b, err := json.Marshal(rh.Records())
+		if err != nil {
+			return fmt.Errorf("marshal failed: %w", err)
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	files := rh.openFiles()
+	rh.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('[')
+	first := true
+	eachLine(files, func(line []byte) {
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		bw.Write(line)
+	})
+	bw.WriteByte(']')
+	return bw.Flush()
+}
+
+// Reset removes all records
+func (rh *RequestHistory) Reset() {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.records = nil
+	if rh.file == nil {
+		return
+	}
+	if err := rh.file.Truncate(0); err != nil {
+		log.Printf("error truncating history file: %s", err)
+	}
+	rh.size = 0
+	if err := os.Remove(rh.file.Name() + ".1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("error removing rotated history file: %s", err)
+	}
+}
+
+// Close closes the file of a file history
+func (rh *RequestHistory) Close() error {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	if rh.file == nil {
+		return nil
+	}
+	return rh.file.Close()
+}
+
+// statusRecorder remembers the status written to the wrapped ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the wrapped ResponseWriter does
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the wrapped ResponseWriter
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// bulkParams returns the bulk query parameters of r
+func bulkParams(r *http.Request) *BulkParams {
+	q := r.URL.Query()
+	bp := &BulkParams{Refresh: q.Get("refresh"), Pipeline: q.Get("pipeline")}
+	if q.Has("require_alias") {
+		bp.RequireAlias = q.Get("require_alias") != "false"
+	}
+	return bp
+}
+
+// recordRequest adds r and the status it was answered with to the history
+func (h *APIHandler) recordRequest(r *http.Request, start time.Time, status int) {
+	if h.RequestHistory == nil || r.URL.Path == "/_history" {
+		return
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	rec := RequestRecord{
+		Time:      start,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		UserAgent: r.UserAgent(),
+		OpaqueID:  r.Header.Get("X-Opaque-Id"),
+		Status:    status,
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		rec.ClientCert = r.TLS.PeerCertificates[0].Subject.String()
+	}
+	if IsBulkPath(r.URL.Path) {
+		rec.Bulk = bulkParams(r)
+	}
+	h.RequestHistory.Add(rec)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter closes writing and blocks on its first write until
+// unblock is closed
+type blockingWriter struct {
+	writing chan struct{}
+	unblock chan struct{}
+	once    sync.Once
+	b       []byte
+}
+
+func (bw *blockingWriter) Write(p []byte) (int, error) {
+	bw.once.Do(func() {
+		close(bw.writing)
+		<-bw.unblock
+	})
+	bw.b = append(bw.b, p...)
+	return len(p), nil
+}
+
+func TestFileHistoryWriteJSONDoesNotBlockAdd(t *testing.T) {
+	rh, err := NewFileRequestHistory(filepath.Join(t.TempDir(), "history.ndjson"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	rh.Add(RequestRecord{Method: "GET", Path: "/"})
+
+	bw := &blockingWriter{writing: make(chan struct{}), unblock: make(chan struct{})}
+	done := make(chan error)
+	go func() { done <- rh.WriteJSON(bw) }()
+	<-bw.writing
+
+	added := make(chan struct{})
+	go func() {
+		rh.Add(RequestRecord{Method: "POST", Path: "/_bulk"})
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Add blocked while the history was written")
+	}
+	close(bw.unblock)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	var records []RequestRecord
+	if err := json.Unmarshal(bw.b, &records); err != nil {
+		t.Fatalf("history %s doesn't decode: %s", bw.b, err)
+	}
+	if len(records) != 1 || records[0].Path != "/" {
+		t.Errorf("history is %+v, want the record added before it was written", records)
+	}
+	if records := rh.Records(); len(records) != 2 {
+		t.Errorf("history has %d records, want 2", len(records))
+	}
+}
+
+func TestWithHistoryFileError(t *testing.T) {
+	if _, err := WithHistoryFile(filepath.Join(t.TempDir(), "missing", "history.ndjson"), 0); err == nil {
+		t.Error("WithHistoryFile returned no error for a file in a missing directory")
+	}
+}
+
+func TestFileHistoryRotateRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.ndjson")
+	// a directory with a file in it can't be replaced by the rotated file
+	if err := os.MkdirAll(filepath.Join(path+".1", "keep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rh, err := NewFileRequestHistory(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	for i := 0; i < 5; i++ {
+		rh.Add(RequestRecord{Method: "POST", Path: "/_bulk"})
+	}
+	if records := rh.Records(); len(records) != 5 {
+		t.Errorf("history has %d records, want the 5 records added after the failed rotation", len(records))
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// indexRegistry keeps the names of the indices that exist, and whether
+// they were explicitly created or auto-created by a bulk request.  The zero
+// value is empty and ready to use.  It is safe for concurrent use.
+type indexRegistry struct {
+	mu    sync.RWMutex
+	names map[string]bool
+}
+
+// add records that index exists.  An explicitly created index stays
+// explicitly created when it is added again.
+func (ir *indexRegistry) add(index string, explicit bool) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	if ir.names == nil {
+		ir.names = make(map[string]bool)
+	}
+	ir.names[index] = ir.names[index] || explicit
+}
+
+// remove records that index no longer exists, returning true if it existed
+func (ir *indexRegistry) remove(index string) bool {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	_, ok := ir.names[index]
+	delete(ir.names, index)
+	return ok
+}
+
+// exists returns true if index exists
+func (ir *indexRegistry) exists(index string) bool {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+	_, ok := ir.names[index]
+	return ok
+}
+
+// match returns the sorted indices matching a comma separated list of
+// names, which can contain * wildcards
+func (ir *indexRegistry) match(names string) []string {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+	var matched []string
+	for name := range ir.names {
+		if matchName(names, name) {
+			matched = append(matched, name)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// created returns true if index was explicitly created
+func (ir *indexRegistry) created(index string) bool {
+	ir.mu.RLock()
+	defer ir.mu.RUnlock()
+	return ir.names[index]
+}
+
+// isIndexPath returns true for the /{index} endpoints.  Names starting with
+// _ are APIs, not indices.
+func isIndexPath(p string) bool {
+	name, ok := strings.CutPrefix(p, "/")
+	return ok && name != "" && !strings.HasPrefix(name, "_") && !strings.Contains(name, "/")
+}
+
+// autoCreateDenied returns true if NoAutoCreate is set and the index of a
+// was not explicitly created or is not a data stream
+func (h *APIHandler) autoCreateDenied(a *bulkAction) bool {
+	return h.NoAutoCreate && !h.indices.created(a.index) && !h.dataStreams.exists(a.index)
+}
+
+// indexExists returns true if index was created, indexed into by a bulk
+// request, or is a data stream
+func (h *APIHandler) indexExists(index string) bool {
+	return h.indices.exists(index) || h.dataStreams.exists(index)
+}
+
+// Index handles /{index} put requests by creating the index with the
+// aliases in the body, delete requests by removing it, its aliases and its
+// stored documents, and head requests by
+// replying StatusOK if the index exists and StatusNotFound otherwise
+func (h *APIHandler) Index(w http.ResponseWriter, r *http.Request) {
+	h.stats.add("index", 1)
+	index := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		incrementCounter(indexCreateMetrics, h.metricsRegistry)
+		if h.indexExists(index) {
+			h.writeError(w, http.StatusBadRequest, "resource_already_exists_exception", fmt.Sprintf("index [%s] already exists", index))
+			return
+		}
+		var body struct {
+			Aliases map[string]struct {
+				IsWriteIndex *bool `json:"is_write_index"`
+			} `json:"aliases"`
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("error reading create index body: %s", err)
+			return
+		}
+		if len(b) != 0 {
+			if err := json.Unmarshal(b, &body); err != nil {
+				h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+				return
+			}
+		}
+		h.indices.add(index, true)
+		for alias, a := range body.Aliases {
+			h.aliases.add(alias, index, a.IsWriteIndex)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"acknowledged": true, "shards_acknowledged": true, "index": index})
+		return
+	case http.MethodDelete:
+		incrementCounter(indexDeleteMetrics, h.metricsRegistry)
+		if !h.indices.remove(index) {
+			h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", index))
+			return
+		}
+		h.aliases.removeIndex(index)
+		h.inferred.remove(index)
+		if h.Store != nil {
+			h.Store.DeleteIndex(index)
+		}
+		writeAcknowledged(w)
+		return
+	}
+
+	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
+	if !h.indexExists(index) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	return
+}
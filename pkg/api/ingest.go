@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ingestPipelinePath is the path of the ingest pipeline endpoints
+const ingestPipelinePath = "/_ingest/pipeline"
+
+// IngestPipeline handles /_ingest/pipeline/{id} put requests by storing the
+// pipeline, and /_ingest/pipeline and /_ingest/pipeline/{id} get requests by
+// returning the stored pipelines.  The pipelines are not executed.
+func (h *APIHandler) IngestPipeline(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(ingestPipelineTotalMetrics, h.metricsRegistry)
+	h.stats.add("ingest_pipeline", 1)
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, ingestPipelinePath), "/")
+	if r.Method == http.MethodPut {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("error reading pipeline body: %s", err)
+			return
+		}
+		var pipeline map[string]any
+		if err := json.Unmarshal(body, &pipeline); err != nil {
+			h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+			return
+		}
+		h.pipelines.put(id, body)
+		writeAcknowledged(w)
+		return
+	}
+
+	pipelines := h.pipelines.all()
+	if id != "" {
+		pipeline, ok := pipelines[id]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{})
+			return
+		}
+		pipelines = map[string]json.RawMessage{id: pipeline}
+	}
+	writeJSON(w, http.StatusOK, pipelines)
+	return
+}
+
+// writeAcknowledged writes the reply of a successful put or delete request
+func writeAcknowledged(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]bool{"acknowledged": true})
+}
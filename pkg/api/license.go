@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// LicenseResponse is the reply to /_license
+type LicenseResponse struct {
+	License LicenseInfo `json:"license"`
+}
+
+// LicenseInfo describes the license of the cluster
+type LicenseInfo struct {
+	Status             string `json:"status"`
+	UID                string `json:"uid"`
+	Type               string `json:"type"`
+	ExpiryDateInMillis int64  `json:"expiry_date_in_millis"`
+}
+
+// license returns the status and expiry returned by /_license, which are
+// expired and the time of the expiration once ExpireLicense was called
+func (h *APIHandler) license() (string, time.Time) {
+	if expiredAt := h.licenseExpiredAt.Load(); expiredAt != nil {
+		return "expired", *expiredAt
+	}
+	return h.LicenseStatus, h.Expire
+}
+
+// ExpireLicense expires the license, /_license then returns an expired
+// status and an expiry in the past.  Only the first call has an effect.
+func (h *APIHandler) ExpireLicense() {
+	now := time.Now().Add(-time.Millisecond)
+	h.licenseExpiredAt.CompareAndSwap(nil, &now)
+}
+
+// MockExpireLicense handles /_mock/expire-license post requests by calling
+// ExpireLicense
+func (h *APIHandler) MockExpireLicense(w http.ResponseWriter, r *http.Request) {
+	h.ExpireLicense()
+	writeAcknowledged(w)
+}
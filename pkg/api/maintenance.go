@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShardsInfo counts the shards a request was performed on, and describes
+// the failed ones
+type ShardsInfo struct {
+	Total      int              `json:"total"`
+	Successful int              `json:"successful"`
+	Skipped    int              `json:"skipped,omitempty"`
+	Failed     int              `json:"failed"`
+	Failures   []map[string]any `json:"failures,omitempty"`
+}
+
+// ShardsResponse is the reply to the index maintenance requests, eg
+// /_refresh
+type ShardsResponse struct {
+	Shards ShardsInfo `json:"_shards"`
+}
+
+// failedShardIndex returns the index whose shard fails, for
+// ShardFailurePercent of the requests to the indices matching names, or an
+// empty string if no shard fails
+func (h *APIHandler) failedShardIndex(names string) string {
+	if h.ShardFailurePercent == 0 || uint(rand.Intn(100)) >= h.ShardFailurePercent {
+		return ""
+	}
+	if indices, _ := h.resolveIndices(names); len(indices) > 0 {
+		return indices[rand.Intn(len(indices))].index
+	}
+	// a write creates its index
+	if !strings.ContainsAny(names, ",*") {
+		return names
+	}
+	return ""
+}
+
+// failShard records that one shard of si failed with failure
+func failShard(si *ShardsInfo, failure map[string]any) {
+	si.Successful--
+	si.Failed++
+	si.Failures = append(si.Failures, failure)
+}
+
+// shardsInfo returns the shards of a search request of index, one copy of
+// each primary shard, with a failed one for ShardFailurePercent of the
+// requests
+func (h *APIHandler) shardsInfo(index string) ShardsInfo {
+	si := ShardsInfo{Total: h.Shards, Successful: h.Shards}
+	if failed := h.failedShardIndex(index); failed != "" {
+		incrementCounter(shardFailureMetrics, h.metricsRegistry)
+		failShard(&si, map[string]any{
+			"shard": rand.Intn(h.Shards),
+			"index": failed,
+			"node":  h.UUID.String(),
+			"reason": map[string]any{
+				"type":   "es_rejected_execution_exception",
+				"reason": fmt.Sprintf("rejected execution of search on node [%s], queue capacity [1000] exceeded", nodeName),
+			},
+		})
+	}
+	return si
+}
+
+// allShardsInfo returns the shards of a broadcast action of index, every
+// primary and replica shard, with a failed one for ShardFailurePercent of
+// the requests
+func (h *APIHandler) allShardsInfo(index, action string) ShardsInfo {
+	total := h.Shards * (1 + h.Replicas)
+	si := ShardsInfo{Total: total, Successful: total}
+	if failed := h.failedShardIndex(index); failed != "" {
+		incrementCounter(shardFailureMetrics, h.metricsRegistry)
+		failShard(&si, map[string]any{
+			"shard":  rand.Intn(h.Shards),
+			"index":  failed,
+			"status": "SERVICE_UNAVAILABLE",
+			"reason": map[string]any{
+				"type":   "no_shard_available_action_exception",
+				"reason": fmt.Sprintf("[%s][127.0.0.1:9300][indices:admin/%s[s]] No shard available", nodeName, action),
+			},
+		})
+	}
+	return si
+}
+
+// docShardsInfo returns the shards of a write of the document with id in
+// index, its primary and replica shards.  For ShardFailurePercent of the
+// requests a replica fails, when there are replicas.
+func (h *APIHandler) docShardsInfo(index, id string) *ShardsInfo {
+	si := &ShardsInfo{Total: 1 + h.Replicas, Successful: 1 + h.Replicas}
+	if h.Replicas == 0 {
+		return si
+	}
+	if failed := h.failedShardIndex(index); failed != "" {
+		incrementCounter(shardFailureMetrics, h.metricsRegistry)
+		replica := fmt.Sprintf("%s-%d", nodeName, 1+rand.Intn(h.Replicas))
+		failShard(si, map[string]any{
+			"_index": failed,
+			"_shard": h.shardFor(map[string]any{"_id": id}),
+			"_node":  uuid.NewSHA1(h.UUID, []byte(replica)).String(),
+			"reason": map[string]any{
+				"type":   "node_disconnected_exception",
+				"reason": fmt.Sprintf("[%s][127.0.0.1:9301][indices:data/write/bulk[s][r]] disconnected", replica),
+			},
+			"status":  "INTERNAL_SERVER_ERROR",
+			"primary": false,
+		})
+	}
+	return si
+}
+
+// Refresh handles /_refresh and /{index}/_refresh get and post requests.
+// Documents are searchable as soon as they are stored, so it does nothing.
+func (h *APIHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(refreshTotalMetrics, h.metricsRegistry)
+	h.stats.add("refresh", 1)
+	h.writeShards(w, r, "refresh")
+}
+
+// Flush handles /_flush and /{index}/_flush get and post requests, it does
+// nothing
+func (h *APIHandler) Flush(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(flushTotalMetrics, h.metricsRegistry)
+	h.stats.add("flush", 1)
+	h.writeShards(w, r, "flush")
+}
+
+// ForceMerge handles /_forcemerge and /{index}/_forcemerge post requests,
+// it does nothing
+func (h *APIHandler) ForceMerge(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(forceMergeTotalMetrics, h.metricsRegistry)
+	h.stats.add("forcemerge", 1)
+	h.writeShards(w, r, "forcemerge")
+}
+
+// writeShards writes the reply of the broadcast action of request r, eg
+// refresh
+func (h *APIHandler) writeShards(w http.ResponseWriter, r *http.Request, action string) {
+	index, _ := indexAPIPath(r.URL.Path, "_"+action)
+	writeJSON(w, http.StatusOK, ShardsResponse{Shards: h.allShardsInfo(index, action)})
+}
+
+// waitForRefresh holds a write request r with refresh=wait_for until the
+// next periodic refresh, every RefreshInterval, like Elasticsearch does.
+// Other refresh values and a RefreshInterval of 0 don't wait.  It returns
+// false if the client gave up while waiting.
+func (h *APIHandler) waitForRefresh(r *http.Request) bool {
+	if h.RefreshInterval <= 0 || r.URL.Query().Get("refresh") != "wait_for" {
+		return true
+	}
+	incrementCounter(refreshWaitForMetrics, h.metricsRegistry)
+	return sleep(r, time.Until(time.Now().Truncate(h.RefreshInterval).Add(h.RefreshInterval)))
+}
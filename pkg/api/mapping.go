@@ -0,0 +1,257 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dateLayouts are the layouts of the strings inferred as dates, like the
+// strict_date_optional_time dynamic date format of Elasticsearch
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+}
+
+// inferredMappings keeps the properties inferred from the first document
+// of each index.  The zero value is empty and ready to use.  It is safe for
+// concurrent use.
+type inferredMappings struct {
+	mu         sync.RWMutex
+	properties map[string]map[string]any
+}
+
+// infer infers the properties of index from doc, unless they were already
+// inferred from an earlier document
+func (im *inferredMappings) infer(index string, doc []byte) {
+	if _, ok := im.get(index); ok {
+		return
+	}
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	var source map[string]any
+	if err := d.Decode(&source); err != nil {
+		log.Printf("error unmarshal document of %s to infer its mapping: %s", index, err)
+		return
+	}
+	properties := inferProperties(source)
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if im.properties == nil {
+		im.properties = make(map[string]map[string]any)
+	}
+	if _, ok := im.properties[index]; !ok {
+		im.properties[index] = properties
+	}
+}
+
+// get returns the properties inferred for index, and whether there are any
+func (im *inferredMappings) get(index string) (map[string]any, bool) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	properties, ok := im.properties[index]
+	return properties, ok
+}
+
+// remove forgets the properties inferred for index
+func (im *inferredMappings) remove(index string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	delete(im.properties, index)
+}
+
+// inferProperties returns the mapping properties of the fields of source
+func inferProperties(source map[string]any) map[string]any {
+	properties := make(map[string]any, len(source))
+	for field, v := range source {
+		if mapping := inferField(v); mapping != nil {
+			properties[field] = mapping
+		}
+	}
+	return properties
+}
+
+// inferField returns the mapping of a field with value v, or nil for null
+// and empty arrays, which don't add a field.  Strings are dates if they
+// match dateLayouts, otherwise text with a keyword multi-field, integers
+// are long, other numbers float, and objects have properties.  The mapping
+// of an array is the one of its first value.
+func inferField(v any) map[string]any {
+	switch v := v.(type) {
+	case string:
+		for _, layout := range dateLayouts {
+			if _, err := time.Parse(layout, v); err == nil {
+				return map[string]any{"type": "date"}
+			}
+		}
+		return map[string]any{"type": "text", "fields": map[string]any{"keyword": map[string]any{"type": "keyword", "ignore_above": 256}}}
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return map[string]any{"type": "long"}
+		}
+		return map[string]any{"type": "float"}
+	case bool:
+		return map[string]any{"type": "boolean"}
+	case map[string]any:
+		return map[string]any{"properties": inferProperties(v)}
+	case []any:
+		for _, e := range v {
+			if mapping := inferField(e); mapping != nil {
+				return mapping
+			}
+		}
+	}
+	return nil
+}
+
+// indexMappings returns the mappings of the index or data stream name,
+// which are those of its template with the properties inferred from its
+// first document added.  The properties of the template take precedence.
+func (h *APIHandler) indexMappings(name string) json.RawMessage {
+	mappings := h.templateMappings(name)
+	inferred, ok := h.inferred.get(name)
+	if !ok {
+		return mappings
+	}
+	var m map[string]any
+	if err := json.Unmarshal(mappings, &m); err != nil || m == nil {
+		m = make(map[string]any)
+	}
+	properties := make(map[string]any, len(inferred))
+	for field, mapping := range inferred {
+		properties[field] = mapping
+	}
+	templateProperties, _ := m["properties"].(map[string]any)
+	for field, mapping := range templateProperties {
+		properties[field] = mapping
+	}
+	m["properties"] = properties
+	b, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("error marshal mappings of %s: %s", name, err)
+		return mappings
+	}
+	return b
+}
+
+// IndexMapping is the mapping of an index in a _mapping response
+type IndexMapping struct {
+	Mappings json.RawMessage `json:"mappings"`
+}
+
+// mappedIndex is an index with the name the templates are matched against,
+// which is the data stream of a backing index
+type mappedIndex struct {
+	index    string
+	template string
+}
+
+// resolveIndices returns the indices and data stream backing indices
+// matching a comma separated list of names with * wildcards, all of them
+// if names is empty.  It returns the first name without wildcards that
+// matches nothing as missing.
+func (h *APIHandler) resolveIndices(names string) (indices []mappedIndex, missing string) {
+	if names == "" || names == "_all" {
+		names = "*"
+	}
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		found := false
+		for _, index := range h.indices.match(name) {
+			found = true
+			if !seen[index] {
+				seen[index] = true
+				indices = append(indices, mappedIndex{index: index, template: index})
+			}
+		}
+		for _, ds := range h.dataStreams.match(name) {
+			found = true
+			for _, dsi := range ds.Indices {
+				if !seen[dsi.IndexName] {
+					seen[dsi.IndexName] = true
+					indices = append(indices, mappedIndex{index: dsi.IndexName, template: ds.Name})
+				}
+			}
+		}
+		if !found && !strings.Contains(name, "*") && missing == "" {
+			missing = name
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i].index < indices[j].index })
+	return indices, missing
+}
+
+// templateMappings returns the mappings of the composable index template
+// with the highest priority matching index, or if there is none of the
+// legacy template with the highest order.  Component templates are not
+// composed.  An index matching no template has empty mappings.
+func (h *APIHandler) templateMappings(index string) json.RawMessage {
+	var best struct {
+		found    bool
+		priority int64
+		mappings json.RawMessage
+	}
+	for name, body := range h.indexTemplates.all() {
+		var t struct {
+			IndexPatterns []string `json:"index_patterns"`
+			Priority      int64    `json:"priority"`
+			Template      struct {
+				Mappings json.RawMessage `json:"mappings"`
+			} `json:"template"`
+		}
+		if err := json.Unmarshal(body, &t); err != nil {
+			log.Printf("error unmarshal index template %s: %s", name, err)
+			continue
+		}
+		if matchName(strings.Join(t.IndexPatterns, ","), index) && (!best.found || t.Priority > best.priority) {
+			best.found, best.priority, best.mappings = true, t.Priority, t.Template.Mappings
+		}
+	}
+	if !best.found {
+		for name, body := range h.legacyTemplates.all() {
+			var t struct {
+				IndexPatterns []string        `json:"index_patterns"`
+				Order         int64           `json:"order"`
+				Mappings      json.RawMessage `json:"mappings"`
+			}
+			if err := json.Unmarshal(body, &t); err != nil {
+				log.Printf("error unmarshal legacy template %s: %s", name, err)
+				continue
+			}
+			if matchName(strings.Join(t.IndexPatterns, ","), index) && (!best.found || t.Order > best.priority) {
+				best.found, best.priority, best.mappings = true, t.Order, t.Mappings
+			}
+		}
+	}
+	if len(best.mappings) == 0 {
+		return json.RawMessage("{}")
+	}
+	return best.mappings
+}
+
+// Mapping handles /_mapping and /{index}/_mapping get requests by returning
+// the mappings of the matching indices, which come from their index
+// templates and, with InferMappings, from their first document
+func (h *APIHandler) Mapping(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(mappingTotalMetrics, h.metricsRegistry)
+	h.stats.add("mapping", 1)
+	names, _ := indexAPIPath(r.URL.Path, "_mapping")
+	indices, missing := h.resolveIndices(names)
+	if missing != "" {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", missing))
+		return
+	}
+	mappings := make(map[string]IndexMapping, len(indices))
+	for _, mi := range indices {
+		mappings[mi.index] = IndexMapping{Mappings: h.indexMappings(mi.template)}
+	}
+	writeJSON(w, http.StatusOK, mappings)
+	return
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// nodeName is the name of the single node of the mock cluster
+	nodeName = "mock"
+	// nodeRoles are the roles of the node, it has all of them
+	nodeRoles = "cdfhilmrstw"
+)
+
+// heapPercent returns the simulated heap usage as percent of HeapWatermark,
+// or 0 if there is no circuit breaker
+func (h *APIHandler) heapPercent() int64 {
+	if h.HeapWatermark <= 0 {
+		return 0
+	}
+	return min(100, h.HeapUsage()*100/h.HeapWatermark)
+}
+
+// CatNodes handles /_cat/nodes get requests.  The id of the single node is
+// the UUID of the handler.
+func (h *APIHandler) CatNodes(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(catNodesTotalMetrics, h.metricsRegistry)
+	h.stats.add("cat_nodes", 1)
+	headers := []string{"ip", "heap.percent", "ram.percent", "cpu", "load_1m", "load_5m", "load_15m", "node.role", "master", "name", "id"}
+	row := []string{"127.0.0.1", strconv.FormatInt(h.heapPercent(), 10), "50", "1", "0.00", "0.00", "0.00", nodeRoles, "*", nodeName, h.UUID.String()}
+	writeCat(w, r, headers, [][]string{row})
+}
+
+// NodesResponse is the reply to /_nodes/http
+type NodesResponse struct {
+	Nodes       NodesHeader         `json:"_nodes"`
+	ClusterName string              `json:"cluster_name"`
+	NodeInfos   map[string]NodeInfo `json:"nodes"`
+}
+
+// NodesHeader counts the nodes that replied
+type NodesHeader struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+// NodeInfo is the http info of a node
+type NodeInfo struct {
+	Name             string            `json:"name"`
+	TransportAddress string            `json:"transport_address"`
+	Host             string            `json:"host"`
+	IP               string            `json:"ip"`
+	Version          string            `json:"version"`
+	BuildFlavor      string            `json:"build_flavor"`
+	Roles            []string          `json:"roles"`
+	Attributes       map[string]string `json:"attributes"`
+	HTTP             NodeHTTP          `json:"http"`
+}
+
+// NodeHTTP is the http section of NodeInfo
+type NodeHTTP struct {
+	BoundAddress            []string `json:"bound_address"`
+	PublishAddress          string   `json:"publish_address"`
+	MaxContentLengthInBytes int64    `json:"max_content_length_in_bytes"`
+}
+
+// isNodesHTTPPath returns true for the paths the clients sniff nodes with
+func isNodesHTTPPath(p string) bool {
+	return p == "/_nodes/http" || p == "/_nodes/_all/http"
+}
+
+// nodeID returns the id of the i-th node publishing address, the first node
+// is the handler's UUID so it matches /_cat/nodes
+func (h *APIHandler) nodeID(i int, address string) string {
+	if i == 0 {
+		return h.UUID.String()
+	}
+	return uuid.NewSHA1(h.UUID, []byte(address)).String()
+}
+
+// NodesHTTP handles /_nodes/http and /_nodes/_all/http get requests, which
+// clients use to sniff the nodes of the cluster.  There is a node for each
+// of SniffNodes, or a single node publishing PublishAddress, or the Host the
+// request was sent to if it is empty.
+func (h *APIHandler) NodesHTTP(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(nodesHTTPTotalMetrics, h.metricsRegistry)
+	h.stats.add("nodes_http", 1)
+	addresses := h.SniffNodes
+	if len(addresses) == 0 {
+		address := h.PublishAddress
+		if address == "" {
+			address = r.Host
+		}
+		addresses = []string{address}
+	}
+	maxContentLength := h.MaxContentLength
+	if maxContentLength == 0 {
+		// the default http.max_content_length of Elasticsearch
+		maxContentLength = 100 << 20
+	}
+	nodes := NodesResponse{
+		Nodes:       NodesHeader{Total: len(addresses), Successful: len(addresses)},
+		ClusterName: h.ClusterName,
+		NodeInfos:   make(map[string]NodeInfo, len(addresses)),
+	}
+	for i, address := range addresses {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		name := nodeName
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d", nodeName, i)
+		}
+		nodes.NodeInfos[h.nodeID(i, address)] = NodeInfo{
+			Name:             name,
+			TransportAddress: net.JoinHostPort(host, strconv.Itoa(9300+i)),
+			Host:             host,
+			IP:               host,
+			Version:          h.version(r),
+			BuildFlavor:      "default",
+			Roles:            []string{"data", "ingest", "master"},
+			Attributes:       map[string]string{},
+			HTTP: NodeHTTP{
+				BoundAddress:            []string{address},
+				PublishAddress:          address,
+				MaxContentLengthInBytes: maxContentLength,
+			},
+		}
+	}
+	writeJSON(w, http.StatusOK, nodes)
+	return
+}
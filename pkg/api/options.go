@@ -0,0 +1,405 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/time/rate"
+)
+
+// handlerConfig collects the settings of Options.  The settings needed to
+// make the APIHandler are kept apart, the other settings are applied to the
+// made APIHandler.
+type handlerConfig struct {
+	uuid             uuid.UUID
+	clusterUUID      string
+	metricsRegistry  metrics.Registry
+	expire           time.Time
+	delay            time.Duration
+	percentDuplicate uint
+	percentTooMany   uint
+	percentNonIndex  uint
+	percentTooLarge  uint
+	percentBulk429   uint
+	apply            []func(*APIHandler)
+}
+
+// Option configures an APIHandler, most options mirror a mock-es flag
+type Option func(*handlerConfig)
+
+// newHandlerConfig returns the defaults of the mock-es flags with opts applied
+func newHandlerConfig(opts []Option) *handlerConfig {
+	c := &handlerConfig{
+		uuid:            uuid.New(),
+		metricsRegistry: metrics.NewRegistry(),
+		expire:          time.Now().Add(24 * time.Hour),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// with returns an Option that applies f to the APIHandler
+func with(f func(*APIHandler)) Option {
+	return func(c *handlerConfig) {
+		c.apply = append(c.apply, f)
+	}
+}
+
+// NewTestServer starts an httptest.Server serving an APIHandler configured
+// by opts, for tests that embed mock-es rather than run the binary.  The
+// caller must Close the server.  When WithHangPercent is used StopHanging
+// must be called first, as Close waits for hung requests.
+func NewTestServer(opts ...Option) (*httptest.Server, *APIHandler) {
+	h := NewAPIHandlerWithOptions(opts...)
+	srv := httptest.NewServer(h)
+	h.SetReady(true)
+	return srv, h
+}
+
+// WithUUID sets the license uid, the default is a random UUID
+func WithUUID(u uuid.UUID) Option {
+	return func(c *handlerConfig) { c.uuid = u }
+}
+
+// WithClusterUUID sets the cluster UUID
+func WithClusterUUID(clusterUUID string) Option {
+	return func(c *handlerConfig) { c.clusterUUID = clusterUUID }
+}
+
+// WithMetricsRegistry sets the registry of the metrics, the default is a
+// new registry so servers don't share their metrics
+func WithMetricsRegistry(r metrics.Registry) Option {
+	return func(c *handlerConfig) { c.metricsRegistry = r }
+}
+
+// WithExpire sets the license expiry, the default is 24 hours from now
+func WithExpire(expire time.Time) Option {
+	return func(c *handlerConfig) { c.expire = expire }
+}
+
+// WithLicense sets the license type, eg basic or platinum, and status, eg
+// active or expired
+func WithLicense(licenseType, status string) Option {
+	return with(func(h *APIHandler) {
+		h.LicenseType = licenseType
+		h.LicenseStatus = status
+	})
+}
+
+// WithLicenseExpiresIn expires the license after d, the expiry is set to d
+// from now
+func WithLicenseExpiresIn(d time.Duration) Option {
+	return func(c *handlerConfig) {
+		c.expire = time.Now().Add(d)
+		c.apply = append(c.apply, func(h *APIHandler) { time.AfterFunc(d, h.ExpireLicense) })
+	}
+}
+
+// WithDelay sets the time to wait before handling each request
+func WithDelay(delay time.Duration) Option {
+	return func(c *handlerConfig) { c.delay = delay }
+}
+
+// WithDuplicatePercent sets the percent chance a create action returns
+// StatusConflict
+func WithDuplicatePercent(percent uint) Option {
+	return func(c *handlerConfig) { c.percentDuplicate = percent }
+}
+
+// WithTooManyPercent sets the percent chance a create action returns
+// StatusTooManyRequests
+func WithTooManyPercent(percent uint) Option {
+	return func(c *handlerConfig) { c.percentTooMany = percent }
+}
+
+// WithNonIndexPercent sets the percent chance a create action returns
+// StatusNotAcceptable
+func WithNonIndexPercent(percent uint) Option {
+	return func(c *handlerConfig) { c.percentNonIndex = percent }
+}
+
+// WithTooLargePercent sets the percent chance a bulk request returns
+// StatusRequestEntityTooLarge
+func WithTooLargePercent(percent uint) Option {
+	return func(c *handlerConfig) { c.percentTooLarge = percent }
+}
+
+// WithBulk429Percent sets the percent chance a bulk request is rejected as
+// a whole with StatusTooManyRequests
+func WithBulk429Percent(percent uint) Option {
+	return func(c *handlerConfig) { c.percentBulk429 = percent }
+}
+
+// WithClusterName sets the cluster name
+func WithClusterName(name string) Option {
+	return with(func(h *APIHandler) { h.ClusterName = name })
+}
+
+// WithVersion sets the Elasticsearch version returned by /
+func WithVersion(version string) Option {
+	return with(func(h *APIHandler) { h.Version = version })
+}
+
+// WithBuildInfo sets the build hash, build date and Lucene version returned
+// by /, empty strings are defaults derived from the version
+func WithBuildInfo(buildHash, buildDate, luceneVersion string) Option {
+	return with(func(h *APIHandler) {
+		h.BuildHash = buildHash
+		h.BuildDate = buildDate
+		h.LuceneVersion = luceneVersion
+	})
+}
+
+// WithHealthStatus sets the cluster health status
+func WithHealthStatus(status string) Option {
+	return with(func(h *APIHandler) { h.HealthStatus = status })
+}
+
+// WithProductHeader sets the X-Elastic-Product header value, empty string
+// omits the header
+func WithProductHeader(product string) Option {
+	return with(func(h *APIHandler) { h.ProductHeader = product })
+}
+
+// WithHeader adds a header to every response
+func WithHeader(name, value string) Option {
+	return with(func(h *APIHandler) {
+		if h.Headers == nil {
+			h.Headers = make(http.Header)
+		}
+		h.Headers.Add(name, value)
+	})
+}
+
+// WithWarning adds a deprecation warning sent on every response
+func WithWarning(message string) Option {
+	return with(func(h *APIHandler) { h.Warnings = append(h.Warnings, message) })
+}
+
+// WithDelayJitter sets the fraction each delay is randomized by
+func WithDelayJitter(jitter float64) Option {
+	return with(func(h *APIHandler) { h.DelayJitter = jitter })
+}
+
+// WithColdStart delays the first requests by an extra delay
+func WithColdStart(delay time.Duration, requests int64) Option {
+	return with(func(h *APIHandler) {
+		h.ColdStart = delay
+		h.ColdStartRequests = requests
+	})
+}
+
+// WithDrip trickles response bodies with delay between each few bytes
+func WithDrip(delay time.Duration) Option {
+	return with(func(h *APIHandler) { h.Drip = delay })
+}
+
+// WithHangPercent sets the percent chance a request never gets a response
+func WithHangPercent(percent uint) Option {
+	return with(func(h *APIHandler) { h.HangPercent = percent })
+}
+
+// WithResetPercent sets the percent chance the connection of a request is
+// closed without a response
+func WithResetPercent(percent uint) Option {
+	return with(func(h *APIHandler) { h.ResetPercent = percent })
+}
+
+// WithH2Scramble sets the maximum random delay added to HTTP/2 requests
+func WithH2Scramble(delay time.Duration) Option {
+	return with(func(h *APIHandler) { h.H2Scramble = delay })
+}
+
+// WithShards sets the number of primary shards and the shard whose create
+// actions are rejected, -1 is no shard rejection
+func WithShards(shards, rejectShard int) Option {
+	return with(func(h *APIHandler) {
+		h.Shards = shards
+		h.RejectShard = rejectShard
+	})
+}
+
+// WithReplicas sets the number of replicas of each primary shard
+func WithReplicas(replicas int) Option {
+	return with(func(h *APIHandler) { h.Replicas = replicas })
+}
+
+// WithShardFailurePercent sets the percent chance a response reports a
+// failed shard
+func WithShardFailurePercent(percent uint) Option {
+	return with(func(h *APIHandler) { h.ShardFailurePercent = percent })
+}
+
+// WithStrictBulk rejects malformed bulk bodies with StatusBadRequest
+func WithStrictBulk() Option {
+	return with(func(h *APIHandler) { h.StrictBulk = true })
+}
+
+// WithStreamBulk writes and flushes bulk responses item by item
+func WithStreamBulk() Option {
+	return with(func(h *APIHandler) { h.StreamBulk = true })
+}
+
+// WithNoAutoCreate fails bulk actions into indices that were not created
+func WithNoAutoCreate() Option {
+	return with(func(h *APIHandler) { h.NoAutoCreate = true })
+}
+
+// WithInferMappings infers the mappings of each index from its first
+// document
+func WithInferMappings() Option {
+	return with(func(h *APIHandler) { h.InferMappings = true })
+}
+
+// WithRefreshInterval makes refresh=wait_for requests wait for the next
+// refresh, every interval
+func WithRefreshInterval(interval time.Duration) Option {
+	return with(func(h *APIHandler) { h.RefreshInterval = interval })
+}
+
+// WithReindexDuration sets how long a _reindex runs before it completes
+func WithReindexDuration(d time.Duration) Option {
+	return with(func(h *APIHandler) { h.ReindexDuration = d })
+}
+
+// WithMaxContentLength sets the maximum decompressed bulk body size
+func WithMaxContentLength(size int64) Option {
+	return with(func(h *APIHandler) { h.MaxContentLength = size })
+}
+
+// WithMaxLineSize sets the maximum size of a line in a bulk body
+func WithMaxLineSize(size int) Option {
+	return with(func(h *APIHandler) { h.MaxLineSize = size })
+}
+
+// WithFailingPipeline sets the ingest pipeline whose actions fail
+func WithFailingPipeline(pipeline string) Option {
+	return with(func(h *APIHandler) { h.FailingPipeline = pipeline })
+}
+
+// WithStore keeps the bulk documents in memory
+func WithStore() Option {
+	return with(func(h *APIHandler) {
+		if h.Store == nil {
+			h.Store = NewDocumentStore()
+		}
+	})
+}
+
+// WithHeapWatermark keeps the bulk documents in memory and sets the
+// simulated heap above which the circuit breaker trips
+func WithHeapWatermark(watermark int64) Option {
+	return with(func(h *APIHandler) {
+		h.HeapWatermark = watermark
+		h.Store = NewDocumentStore()
+	})
+}
+
+// WithRetryAfter sets the Retry-After header of rejected requests
+func WithRetryAfter(retryAfter time.Duration) Option {
+	return with(func(h *APIHandler) { h.RetryAfter = retryAfter })
+}
+
+// WithRPS sets the requests per second above which requests are rejected
+func WithRPS(rps float64) Option {
+	return with(func(h *APIHandler) {
+		h.RateLimiter = rate.NewLimiter(rate.Limit(rps), int(math.Max(1, math.Ceil(rps))))
+	})
+}
+
+// WithHistoryCap records up to capacity requests in /_history
+func WithHistoryCap(capacity int) Option {
+	return with(func(h *APIHandler) { h.RequestHistory = NewRequestHistory(capacity) })
+}
+
+// WithHistoryFile records the requests in /_history to the file at path,
+// rotated when it grows over maxSize bytes.  It returns an error if the
+// file can't be opened.  The caller must Close the RequestHistory.
+func WithHistoryFile(path string, maxSize int64) (Option, error) {
+	rh, err := NewFileRequestHistory(path, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return with(func(h *APIHandler) { h.RequestHistory = rh }), nil
+}
+
+// WithReplay serves the recorded responses of entries
+func WithReplay(entries ...ReplayEntry) Option {
+	return with(func(h *APIHandler) { h.Replay = NewReplay(entries) })
+}
+
+// WithUpstream forwards the requests to the Elasticsearch at u, with the
+// delays and faults of the other options
+func WithUpstream(u *url.URL) Option {
+	return with(func(h *APIHandler) { h.Upstream = NewUpstreamProxy(u) })
+}
+
+// WithItemStatusFunc sets ItemStatusFunc
+func WithItemStatusFunc(f func(action string, meta, doc map[string]any) int) Option {
+	return with(func(h *APIHandler) { h.ItemStatusFunc = f })
+}
+
+// WithDefaultResponse sets the status and body of requests to unknown
+// paths, an empty body is the tagline or an Elasticsearch error
+func WithDefaultResponse(status int, body string) Option {
+	return with(func(h *APIHandler) {
+		h.DefaultStatus = status
+		h.DefaultBody = body
+	})
+}
+
+// WithPublishAddress sets the address returned to sniffing clients
+func WithPublishAddress(address string) Option {
+	return with(func(h *APIHandler) { h.PublishAddress = address })
+}
+
+// WithSniffNodes sets the addresses of the nodes returned to sniffing clients
+func WithSniffNodes(addresses ...string) Option {
+	return with(func(h *APIHandler) { h.SniffNodes = addresses })
+}
+
+// WithPathFault returns status for percent of the requests to the paths
+// matching pattern, with body or an Elasticsearch error if it is empty
+func WithPathFault(pattern string, status int, percent uint, body string) Option {
+	return with(func(h *APIHandler) {
+		if h.PathFaults == nil {
+			h.PathFaults = make(map[string]PathFault)
+		}
+		h.PathFaults[pattern] = PathFault{Status: status, Percent: &percent, Body: body}
+	})
+}
+
+// WithHealthyFor serves the first requests bulk requests without random
+// faults
+func WithHealthyFor(requests int64) Option {
+	return with(func(h *APIHandler) { h.HealthyFor = requests })
+}
+
+// WithFailAfter fails every bulk request after the first requests ones
+func WithFailAfter(requests int64) Option {
+	return with(func(h *APIHandler) { h.FailAfter = requests })
+}
+
+// WithIndexMetrics counts the bulk actions per index for the indices
+// matching a comma separated list of names
+func WithIndexMetrics(indices string) Option {
+	return with(func(h *APIHandler) { h.IndexMetrics = indices })
+}
+
+// WithMaxUserAgents sets the number of distinct user agents tracked
+func WithMaxUserAgents(agents int) Option {
+	return with(func(h *APIHandler) { h.UserAgentTracker.MaxAgents = agents })
+}
+
+// WithDeniedPrivileges denies the privileges matching a comma separated
+// list of names in _has_privileges
+func WithDeniedPrivileges(privileges string) Option {
+	return with(func(h *APIHandler) { h.DeniedPrivileges = privileges })
+}
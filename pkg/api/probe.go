@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+)
+
+// isProbePath returns true for the liveness and readiness probes of the
+// mock itself, which are not Elasticsearch endpoints
+func isProbePath(p string) bool {
+	return p == "/_mock/healthz" || p == "/_mock/readyz"
+}
+
+// SetReady sets whether /_mock/readyz replies the handler is ready.  A
+// handler is not ready until it is set ready, eg once its server listens.
+func (h *APIHandler) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// Healthz handles /_mock/healthz get requests by replying ok, as long as
+// the server is serving requests
+func (h *APIHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "text/plain; charset=UTF-8")
+	w.Write([]byte("ok"))
+}
+
+// Readyz handles /_mock/readyz get requests by replying ok once the handler
+// was set ready, and StatusServiceUnavailable before
+func (h *APIHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "text/plain; charset=UTF-8")
+	if !h.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready"))
+		return
+	}
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// NewUpstreamProxy returns a reverse proxy to the Elasticsearch at u, for
+// use as Upstream
+func NewUpstreamProxy(u *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(u)
+			pr.SetXForwarded()
+		},
+	}
+}
+
+// isMockPath returns true for the endpoints of the mock itself, which are
+// never proxied
+func isMockPath(p string) bool {
+	return p == "/_history" || strings.HasPrefix(p, "/_mock/")
+}
+
+// proxy forwards r to Upstream.  Bulk requests can still be rejected as a
+// whole like the mock does, then they are not forwarded.  The failures of
+// single bulk actions are not injected into the upstream responses.
+func (h *APIHandler) proxy(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(upstreamTotalMetrics, h.metricsRegistry)
+	if IsBulkPath(r.URL.Path) {
+		h.stats.add("bulk", 1)
+		if _, rejected := h.rejectBulk(w); rejected {
+			return
+		}
+	}
+	// the upstream sends its own product header, and echoes X-Opaque-Id
+	w.Header().Del(http.CanonicalHeaderKey("X-Elastic-Product"))
+	w.Header().Del(http.CanonicalHeaderKey("X-Opaque-Id"))
+	h.Upstream.ServeHTTP(w, r)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ReindexRequest is the body of a /_reindex request, only the source and
+// destination indices are used
+type ReindexRequest struct {
+	Source struct {
+		Index indexNames `json:"index"`
+	} `json:"source"`
+	Dest struct {
+		Index  string `json:"index"`
+		OpType string `json:"op_type"`
+	} `json:"dest"`
+}
+
+// indexNames is a list of index names given as a string or an array of
+// strings, decoded as a comma separated list
+type indexNames string
+
+// UnmarshalJSON decodes a string or an array of strings into n
+func (n *indexNames) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		var name string
+		if err := json.Unmarshal(b, &name); err != nil {
+			return err
+		}
+		names = []string{name}
+	}
+	*n = indexNames(strings.Join(names, ","))
+	return nil
+}
+
+// ReindexRetries counts the retries of a reindex
+type ReindexRetries struct {
+	Bulk   int `json:"bulk"`
+	Search int `json:"search"`
+}
+
+// ReindexResponse is the reply to a /_reindex request waiting for its
+// completion, and the response of a completed reindex task
+type ReindexResponse struct {
+	Took                 int64          `json:"took"`
+	TimedOut             bool           `json:"timed_out"`
+	Total                int64          `json:"total"`
+	Updated              int64          `json:"updated"`
+	Created              int64          `json:"created"`
+	Deleted              int64          `json:"deleted"`
+	Batches              int64          `json:"batches"`
+	VersionConflicts     int64          `json:"version_conflicts"`
+	Noops                int64          `json:"noops"`
+	Retries              ReindexRetries `json:"retries"`
+	ThrottledMillis      int64          `json:"throttled_millis"`
+	RequestsPerSecond    float64        `json:"requests_per_second"`
+	ThrottledUntilMillis int64          `json:"throttled_until_millis"`
+	Failures             []any          `json:"failures"`
+}
+
+// reindex copies the stored documents of the source indices into the
+// destination index of rr, like the index actions of a bulk request
+func (h *APIHandler) reindex(rr ReindexRequest) ReindexResponse {
+	resp := ReindexResponse{RequestsPerSecond: -1, Failures: []any{}}
+	if h.Store == nil {
+		// without documents to copy the destination is still created
+		h.indices.add(rr.Dest.Index, false)
+		return resp
+	}
+	docs, _ := h.Store.Search(string(rr.Source.Index), 0, math.MaxInt)
+	for _, sd := range docs {
+		resp.Total++
+		if rr.Dest.OpType == "create" {
+			if _, ok := h.Store.Get(rr.Dest.Index, sd.ID); ok {
+				resp.VersionConflicts++
+				continue
+			}
+		}
+		if _, created := h.storeDocument(&bulkAction{action: "index", index: rr.Dest.Index, id: sd.ID, doc: sd.Source}); created {
+			resp.Created++
+		} else {
+			resp.Updated++
+		}
+	}
+	// a scroll batch is 1000 documents by default
+	resp.Batches = (resp.Total + 999) / 1000
+	return resp
+}
+
+// Reindex handles /_reindex post requests by copying the stored documents
+// of the source indices into the destination index when the request is
+// received.  The reindex then runs as a synthetic task for ReindexDuration,
+// the reply is sent when it completes, or with wait_for_completion=false
+// is the task id right away.
+func (h *APIHandler) Reindex(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(reindexTotalMetrics, h.metricsRegistry)
+	h.stats.add("reindex", 1)
+	start := time.Now()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("error reading reindex body: %s", err)
+		return
+	}
+	var rr ReindexRequest
+	if err := json.Unmarshal(body, &rr); err != nil {
+		h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+		return
+	}
+	var invalid []string
+	if rr.Source.Index == "" {
+		invalid = append(invalid, "use _all if you really want to copy from all existing indexes")
+	}
+	if rr.Dest.Index == "" {
+		invalid = append(invalid, "index must be specified")
+	}
+	if len(invalid) != 0 {
+		reason := "Validation Failed: "
+		for i, s := range invalid {
+			reason += fmt.Sprintf("%d: %s;", i+1, s)
+		}
+		h.writeError(w, http.StatusBadRequest, "action_request_validation_exception", reason)
+		return
+	}
+	if _, missing := h.resolveIndices(string(rr.Source.Index)); missing != "" {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", missing))
+		return
+	}
+	if h.autoCreateDenied(&bulkAction{index: rr.Dest.Index}) {
+		h.writeError(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", rr.Dest.Index))
+		return
+	}
+	resp := h.reindex(rr)
+	resp.Took = h.ReindexDuration.Milliseconds()
+	description := fmt.Sprintf("reindex from [%s] to [%s]", rr.Source.Index, rr.Dest.Index)
+	taskID := h.StartTask("indices:data/write/reindex", description, h.ReindexDuration, resp)
+	var reply any = map[string]string{"task": taskID}
+	if r.URL.Query().Get("wait_for_completion") != "false" {
+		if !sleep(r, h.ReindexDuration-time.Since(start)) {
+			return
+		}
+		resp.Took = time.Since(start).Milliseconds()
+		reply = resp
+	}
+	writeJSON(w, http.StatusOK, reply)
+	return
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReindexWithoutStoreCreatesDest(t *testing.T) {
+	srv, _ := NewTestServer()
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/src", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT /src failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT /src status is %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/_reindex", "application/json", strings.NewReader(`{"source":{"index":"src"},"dest":{"index":"dest"}}`))
+	if err != nil {
+		t.Fatalf("POST /_reindex failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /_reindex status is %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = http.Head(srv.URL + "/dest")
+	if err != nil {
+		t.Fatalf("HEAD /dest failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("HEAD /dest status is %d, want 200", resp.StatusCode)
+	}
+}
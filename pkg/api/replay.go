@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// ReplayEntry is a recorded request and the response to replay for it.
+// A capture file has one entry per line, eg:
+// {"method":"GET","path":"/_cat/indices","response":{"status":200,"body":"green open logs"}}
+type ReplayEntry struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// BodySHA256 is the hex SHA-256 of the request body, empty string
+	// matches any body
+	BodySHA256 string         `json:"body_sha256,omitempty"`
+	Response   ReplayResponse `json:"response"`
+}
+
+// ReplayResponse is a recorded response
+type ReplayResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	// Body is written verbatim if it is a JSON string, and as JSON
+	// otherwise
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// replayKey is the method and path entries are matched on
+type replayKey struct {
+	method, path string
+}
+
+// Replay serves recorded responses for matching requests.  Entries with
+// the same method, path and body are replayed in order, the last one is
+// repeated.  It is safe for concurrent use.
+type Replay struct {
+	mu      sync.Mutex
+	entries map[replayKey][]ReplayEntry
+	// next is the index of the next entry to replay by sequence
+	next map[string]int
+}
+
+// NewReplay returns a Replay of entries
+func NewReplay(entries []ReplayEntry) *Replay {
+	rp := &Replay{entries: make(map[replayKey][]ReplayEntry), next: make(map[string]int)}
+	for _, e := range entries {
+		k := replayKey{e.Method, e.Path}
+		rp.entries[k] = append(rp.entries[k], e)
+	}
+	return rp
+}
+
+// LoadReplay returns a Replay of the entries in the capture file at path,
+// one JSON entry per line
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open failed: %w", err)
+	}
+	defer f.Close()
+	var entries []ReplayEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, DefaultMaxLineSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var e ReplayEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if e.Method == "" || e.Path == "" || e.Response.Status == 0 {
+			return nil, fmt.Errorf("line %d: method, path and response status are required", line)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read failed: %w", err)
+	}
+	return NewReplay(entries), nil
+}
+
+// match returns the response for r, and whether there is one.  An entry
+// with the hash of the body of r takes precedence over the entries that
+// match any body.  The body of r is restored for the handlers when there
+// is no match.
+func (rp *Replay) match(r *http.Request) (ReplayResponse, bool) {
+	candidates := rp.entries[replayKey{r.Method, r.URL.Path}]
+	if len(candidates) == 0 {
+		return ReplayResponse{}, false
+	}
+	bodyHash := ""
+	for _, e := range candidates {
+		if e.BodySHA256 == "" {
+			continue
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("error reading replay body: %s", err)
+			return ReplayResponse{}, false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		bodyHash = hex.EncodeToString(sum[:])
+		break
+	}
+	var matched []ReplayEntry
+	for _, e := range candidates {
+		if bodyHash != "" && e.BodySHA256 == bodyHash {
+			matched = append(matched, e)
+		}
+	}
+	if len(matched) == 0 {
+		for _, e := range candidates {
+			if e.BodySHA256 == "" {
+				matched = append(matched, e)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return ReplayResponse{}, false
+	}
+	seq := r.Method + " " + r.URL.Path + " " + matched[0].BodySHA256
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	i := rp.next[seq]
+	if i < len(matched)-1 {
+		rp.next[seq] = i + 1
+	}
+	return matched[i].Response, true
+}
+
+// serve writes the recorded response for r, returning true if there is one
+func (rp *Replay) serve(w http.ResponseWriter, r *http.Request) bool {
+	resp, ok := rp.match(r)
+	if !ok {
+		return false
+	}
+	for name, values := range resp.Headers {
+		w.Header().Del(name)
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	body := []byte(resp.Body)
+	var s string
+	if err := json.Unmarshal(resp.Body, &s); err == nil {
+		body = []byte(s)
+	} else if len(body) > 0 && resp.Headers.Get("Content-Type") == "" {
+		w.Header().Set(http.CanonicalHeaderKey("Content-Type"), "application/json")
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(body)
+	return true
+}
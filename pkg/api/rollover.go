@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// rolloverIndexPattern matches index names that can be rolled over without
+// an explicit new index name, eg logs-000001
+var rolloverIndexPattern = regexp.MustCompile(`^(.*)-(\d+)$`)
+
+// RolloverResponse is an Elasticsearch rollover response
+type RolloverResponse struct {
+	Acknowledged       bool            `json:"acknowledged"`
+	ShardsAcknowledged bool            `json:"shards_acknowledged"`
+	OldIndex           string          `json:"old_index"`
+	NewIndex           string          `json:"new_index"`
+	RolledOver         bool            `json:"rolled_over"`
+	DryRun             bool            `json:"dry_run"`
+	Conditions         map[string]bool `json:"conditions"`
+}
+
+// rolloverPath returns the target and the optional new index name of a
+// /{target}/_rollover or /{target}/_rollover/{new_index} path, or false if p
+// is not such a path
+func rolloverPath(p string) (target, newIndex string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "_rollover" {
+		return "", "", false
+	}
+	if parts[0] == "" || strings.HasPrefix(parts[0], "_") {
+		return "", "", false
+	}
+	if len(parts) == 3 {
+		if parts[2] == "" {
+			return "", "", false
+		}
+		newIndex = parts[2]
+	}
+	return parts[0], newIndex, true
+}
+
+// isRolloverPath returns true for the /{target}/_rollover endpoints
+func isRolloverPath(p string) bool {
+	_, _, ok := rolloverPath(p)
+	return ok
+}
+
+// nextRolloverIndex returns the index following index, eg logs-000002
+// follows logs-000001, or false if index doesn't end with a number
+func nextRolloverIndex(index string) (string, bool) {
+	m := rolloverIndexPattern.FindStringSubmatch(index)
+	if m == nil {
+		return "", false
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s-%06d", m[1], n+1), true
+}
+
+// Rollover handles /{target}/_rollover post requests.  For an alias a new
+// index is created and the alias is pointed to it, for a data stream a new
+// backing index is added.  Conditions are not evaluated, every request
+// rolls over unless dry_run is set.
+func (h *APIHandler) Rollover(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(rolloverTotalMetrics, h.metricsRegistry)
+	h.stats.add("rollover", 1)
+	target, newIndex, _ := rolloverPath(r.URL.Path)
+	dryRun := r.URL.Query().Has("dry_run") && r.URL.Query().Get("dry_run") != "false"
+	rr := RolloverResponse{Acknowledged: !dryRun, ShardsAcknowledged: !dryRun, RolledOver: !dryRun, DryRun: dryRun, Conditions: map[string]bool{}}
+
+	if h.dataStreams.exists(target) {
+		rr.OldIndex, rr.NewIndex = h.dataStreams.rollover(target, dryRun)
+		h.writeRollover(w, rr)
+		return
+	}
+
+	oldIndex, isWriteIndex, ok := h.aliases.writeIndex(target)
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("rollover target [%s] does not exist", target))
+		return
+	}
+	if newIndex == "" {
+		newIndex, ok = nextRolloverIndex(oldIndex)
+		if !ok {
+			h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("index name [%s] does not match pattern '^.*-\\d+$'", oldIndex))
+			return
+		}
+	}
+	if h.indexExists(newIndex) {
+		h.writeError(w, http.StatusBadRequest, "resource_already_exists_exception", fmt.Sprintf("index [%s] already exists", newIndex))
+		return
+	}
+	rr.OldIndex, rr.NewIndex = oldIndex, newIndex
+	if !dryRun {
+		h.indices.add(newIndex, true)
+		// like Elasticsearch, an alias with an explicit write index keeps
+		// pointing to the old index, otherwise it is moved to the new one
+		if isWriteIndex != nil && *isWriteIndex {
+			h.aliases.add(target, newIndex, isWriteIndex)
+		} else {
+			h.aliases.remove(target, oldIndex)
+			h.aliases.add(target, newIndex, nil)
+		}
+	}
+	h.writeRollover(w, rr)
+	return
+}
+
+// writeRollover writes the rollover response rr
+func (h *APIHandler) writeRollover(w http.ResponseWriter, rr RolloverResponse) {
+	writeJSON(w, http.StatusOK, rr)
+}
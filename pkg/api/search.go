@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultSearchSize is the number of hits returned when a search doesn't
+// set size
+const defaultSearchSize = 10
+
+// SearchResponse is the reply to a search
+type SearchResponse struct {
+	Took     int64      `json:"took"`
+	TimedOut bool       `json:"timed_out"`
+	Shards   ShardsInfo `json:"_shards"`
+	Hits     SearchHits `json:"hits"`
+	Status   int        `json:"status,omitempty"`
+}
+
+// SearchHits are the hits of a SearchResponse
+type SearchHits struct {
+	Total    SearchTotal `json:"total"`
+	MaxScore *float64    `json:"max_score"`
+	Hits     []SearchHit `json:"hits"`
+}
+
+// SearchTotal is the number of documents matching a search
+type SearchTotal struct {
+	Value    int64  `json:"value"`
+	Relation string `json:"relation"`
+}
+
+// SearchHit is a document matching a search
+type SearchHit struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Score  float64         `json:"_score"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// MultiSearchResponse is the reply to /_msearch
+type MultiSearchResponse struct {
+	Took      int64            `json:"took"`
+	Responses []SearchResponse `json:"responses"`
+}
+
+// searchRequest is the part of a search body the mock understands, every
+// query is treated as match_all
+type searchRequest struct {
+	From *int `json:"from"`
+	Size *int `json:"size"`
+}
+
+// search returns the response to a search of index, a comma separated list
+// of names, over the stored documents
+func (h *APIHandler) search(index string, req searchRequest, start time.Time) SearchResponse {
+	sr := SearchResponse{Shards: h.shardsInfo(index), Hits: SearchHits{Total: SearchTotal{Relation: "eq"}, Hits: []SearchHit{}}}
+	if h.Store != nil {
+		from, size := 0, defaultSearchSize
+		if req.From != nil {
+			from = *req.From
+		}
+		if req.Size != nil {
+			size = *req.Size
+		}
+		docs, total := h.Store.Search(index, from, size)
+		sr.Hits.Total.Value = total
+		for _, doc := range docs {
+			sr.Hits.Hits = append(sr.Hits.Hits, SearchHit{Index: doc.Index, ID: doc.ID, Score: 1, Source: doc.Source})
+		}
+	}
+	if len(sr.Hits.Hits) > 0 {
+		score := 1.0
+		sr.Hits.MaxScore = &score
+	}
+	sr.Took = time.Since(start).Milliseconds()
+	return sr
+}
+
+// msearchIndex returns the indices of an msearch header, which are a string
+// or an array of strings, joined by commas
+func msearchIndex(header map[string]any) string {
+	switch index := header["index"].(type) {
+	case string:
+		return index
+	case []any:
+		names := make([]string, 0, len(index))
+		for _, name := range index {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return strings.Join(names, ",")
+	}
+	return ""
+}
+
+// Msearch handles /_msearch and /{index}/_msearch get and post requests.
+// The body is read like a bulk body, as pairs of a header line and a search
+// line, and each search is answered from the stored documents.
+func (h *APIHandler) Msearch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	incrementCounter(msearchTotalMetrics, h.metricsRegistry)
+	h.stats.add("msearch", 1)
+	pathIndex, _ := indexAPIPath(r.URL.Path, "_msearch")
+	body, err := decodedBody(r)
+	if err != nil {
+		h.writeDecodeError(w, err)
+		return
+	}
+	scanner := h.newLineScanner(body)
+	msr := MultiSearchResponse{Responses: []SearchResponse{}}
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var header map[string]any
+		if err := json.Unmarshal(b, &header); err != nil {
+			h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("Malformed search header on line [%d], expected a JSON object but found [%s]", line, b))
+			return
+		}
+		var req searchRequest
+		if scanner.Scan() {
+			line++
+			if b := bytes.TrimSpace(scanner.Bytes()); len(b) > 0 {
+				if err := json.Unmarshal(b, &req); err != nil {
+					h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("Malformed search body on line [%d], expected a JSON object but found [%s]", line, b))
+					return
+				}
+			}
+		}
+		index := msearchIndex(header)
+		if index == "" {
+			index = pathIndex
+		}
+		sr := h.search(index, req, start)
+		sr.Status = http.StatusOK
+		msr.Responses = append(msr.Responses, sr)
+	}
+	if err := scanner.Err(); err != nil {
+		h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("Failed to read line [%d]: %s", line+1, err))
+		return
+	}
+	msr.Took = time.Since(start).Milliseconds()
+	writeJSON(w, http.StatusOK, msr)
+	return
+}
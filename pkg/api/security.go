@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// HasPrivilegesRequest is the body of a /_security/user/_has_privileges
+// request
+type HasPrivilegesRequest struct {
+	Cluster     []string                `json:"cluster"`
+	Index       []IndexPrivileges       `json:"index"`
+	Application []ApplicationPrivileges `json:"application"`
+}
+
+// IndexPrivileges are the privileges requested on indices
+type IndexPrivileges struct {
+	Names      []string `json:"names"`
+	Privileges []string `json:"privileges"`
+}
+
+// ApplicationPrivileges are the privileges requested on the resources of
+// an application
+type ApplicationPrivileges struct {
+	Application string   `json:"application"`
+	Privileges  []string `json:"privileges"`
+	Resources   []string `json:"resources"`
+}
+
+// HasPrivilegesResponse is the reply to /_security/user/_has_privileges
+type HasPrivilegesResponse struct {
+	Username        string                                `json:"username"`
+	HasAllRequested bool                                  `json:"has_all_requested"`
+	Cluster         map[string]bool                       `json:"cluster"`
+	Index           map[string]map[string]bool            `json:"index"`
+	Application     map[string]map[string]map[string]bool `json:"application"`
+}
+
+// isHasPrivilegesPath returns true for the /_security/user/_has_privileges
+// and /_security/user/{user}/_has_privileges endpoints
+func isHasPrivilegesPath(p string) bool {
+	rest, ok := strings.CutPrefix(p, "/_security/user/")
+	if !ok {
+		return false
+	}
+	if rest == "_has_privileges" {
+		return true
+	}
+	user, ok := strings.CutSuffix(rest, "/_has_privileges")
+	return ok && user != "" && !strings.Contains(user, "/")
+}
+
+// granted returns true unless privilege is one of DeniedPrivileges
+func (h *APIHandler) granted(privilege string) bool {
+	return h.DeniedPrivileges == "" || !matchName(h.DeniedPrivileges, privilege)
+}
+
+// HasPrivileges handles /_security/user/_has_privileges get and post
+// requests.  Every requested privilege is granted, except those matching
+// DeniedPrivileges.  The username is the one of the path, of the basic
+// authentication of the request, or elastic.
+func (h *APIHandler) HasPrivileges(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(hasPrivilegesTotalMetrics, h.metricsRegistry)
+	h.stats.add("has_privileges", 1)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("error reading has privileges body: %s", err)
+		return
+	}
+	var req HasPrivilegesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+		return
+	}
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		username = "elastic"
+	}
+	if user, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/_security/user/"), "/_has_privileges"); ok {
+		username = user
+	}
+	hpr := HasPrivilegesResponse{
+		Username:        username,
+		HasAllRequested: true,
+		Cluster:         make(map[string]bool),
+		Index:           make(map[string]map[string]bool),
+		Application:     make(map[string]map[string]map[string]bool),
+	}
+	grant := func(privileges []string) map[string]bool {
+		m := make(map[string]bool, len(privileges))
+		for _, p := range privileges {
+			m[p] = h.granted(p)
+			hpr.HasAllRequested = hpr.HasAllRequested && m[p]
+		}
+		return m
+	}
+	for p, ok := range grant(req.Cluster) {
+		hpr.Cluster[p] = ok
+	}
+	for _, ip := range req.Index {
+		for _, name := range ip.Names {
+			hpr.Index[name] = grant(ip.Privileges)
+		}
+	}
+	for _, ap := range req.Application {
+		resources := make(map[string]map[string]bool, len(ap.Resources))
+		for _, resource := range ap.Resources {
+			resources[resource] = grant(ap.Privileges)
+		}
+		hpr.Application[ap.Application] = resources
+	}
+	writeJSON(w, http.StatusOK, hpr)
+	return
+}
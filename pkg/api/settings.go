@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// clusterSettings keeps the persistent and transient cluster settings by
+// flat key, eg "cluster.routing.allocation.enable".  The zero value is
+// empty and ready to use.  It is safe for concurrent use.
+type clusterSettings struct {
+	mu         sync.RWMutex
+	persistent map[string]any
+	transient  map[string]any
+}
+
+// update sets the settings, a null value resets a setting.  Like
+// Elasticsearch, it returns an error and keeps the settings unchanged if a
+// setting would be both a value and an object, eg "a" and "a.b".
+func (cs *clusterSettings) update(persistent, transient map[string]any) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	p := mergeSettings(cs.persistent, persistent)
+	t := mergeSettings(cs.transient, transient)
+	for _, flat := range []map[string]any{flattenSettings("", persistent), flattenSettings("", transient), p, t} {
+		if err := settingsConflict(flat); err != nil {
+			return err
+		}
+	}
+	cs.persistent, cs.transient = p, t
+	return nil
+}
+
+// get returns copies of the persistent and transient settings
+func (cs *clusterSettings) get() (persistent, transient map[string]any) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return mergeSettings(nil, cs.persistent), mergeSettings(nil, cs.transient)
+}
+
+// mergeSettings returns settings with the flattened updates applied
+func mergeSettings(settings, updates map[string]any) map[string]any {
+	merged := make(map[string]any, len(settings))
+	for k, v := range settings {
+		merged[k] = v
+	}
+	for k, v := range flattenSettings("", updates) {
+		if v == nil {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// flattenSettings returns nested settings keyed by their dotted path.
+// Like Elasticsearch, values are kept as strings.
+func flattenSettings(prefix string, settings map[string]any) map[string]any {
+	flat := make(map[string]any)
+	for k, v := range settings {
+		key := prefix + k
+		switch v := v.(type) {
+		case map[string]any:
+			for fk, fv := range flattenSettings(key+".", v) {
+				flat[fk] = fv
+			}
+		case nil:
+			flat[key] = nil
+		case string:
+			flat[key] = v
+		case bool:
+			flat[key] = strconv.FormatBool(v)
+		default:
+			flat[key] = fmt.Sprint(v)
+		}
+	}
+	return flat
+}
+
+// settingsConflict returns an error for the first setting, in sorted
+// order, whose key is the prefix of another setting
+func settingsConflict(flat map[string]any) error {
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for i := len(k) - 1; i > 0; i-- {
+			if k[i] != '.' {
+				continue
+			}
+			if _, ok := flat[k[:i]]; ok {
+				return fmt.Errorf("can't set [%s] and [%s], [%s] can't be both a value and an object", k[:i], k, k[:i])
+			}
+		}
+	}
+	return nil
+}
+
+// nestSettings returns flat settings as nested objects, the inverse of
+// flattenSettings
+func nestSettings(flat map[string]any) map[string]any {
+	nested := make(map[string]any)
+	for k, v := range flat {
+		parts := strings.Split(k, ".")
+		m := nested
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := m[part].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				m[part] = child
+			}
+			m = child
+		}
+		m[parts[len(parts)-1]] = v
+	}
+	return nested
+}
+
+// defaultClusterSettings returns the defaults of a few commonly read
+// cluster settings
+func (h *APIHandler) defaultClusterSettings() map[string]any {
+	return map[string]any{
+		"action.auto_create_index":          strconv.FormatBool(!h.NoAutoCreate),
+		"action.destructive_requires_name":  "true",
+		"cluster.max_shards_per_node":       "1000",
+		"cluster.routing.allocation.enable": "all",
+	}
+}
+
+// ClusterSettings handles /_cluster/settings put requests by storing the
+// persistent and transient settings, and get requests by returning them.
+// Like Elasticsearch, the defaults are only returned with include_defaults
+// and the settings are flat with flat_settings.
+func (h *APIHandler) ClusterSettings(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(clusterSettingsTotalMetrics, h.metricsRegistry)
+	h.stats.add("cluster_settings", 1)
+	q := r.URL.Query()
+	format := nestSettings
+	if q.Get("flat_settings") == "true" {
+		format = func(flat map[string]any) map[string]any { return flat }
+	}
+	var reply map[string]any
+	if r.Method == http.MethodPut {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("error reading cluster settings body: %s", err)
+			return
+		}
+		var settings struct {
+			Persistent map[string]any `json:"persistent"`
+			Transient  map[string]any `json:"transient"`
+		}
+		if err := json.Unmarshal(body, &settings); err != nil {
+			h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+			return
+		}
+		if err := h.clusterSettings.update(settings.Persistent, settings.Transient); err != nil {
+			h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+			return
+		}
+		reply = map[string]any{
+			"acknowledged": true,
+			"persistent":   format(flattenSettings("", settings.Persistent)),
+			"transient":    format(flattenSettings("", settings.Transient)),
+		}
+	} else {
+		persistent, transient := h.clusterSettings.get()
+		reply = map[string]any{"persistent": format(persistent), "transient": format(transient)}
+		if q.Get("include_defaults") == "true" {
+			reply["defaults"] = format(h.defaultClusterSettings())
+		}
+	}
+	writeJSON(w, http.StatusOK, reply)
+	return
+}
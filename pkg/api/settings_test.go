@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// putClusterSettings sends body to PUT /_cluster/settings of url and
+// returns the status and decoded reply
+func putClusterSettings(t *testing.T, url, body string) (int, map[string]any) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPut, url+"/_cluster/settings", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT /_cluster/settings failed: %s", err)
+	}
+	defer resp.Body.Close()
+	var reply map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatalf("PUT /_cluster/settings reply doesn't decode: %s", err)
+	}
+	return resp.StatusCode, reply
+}
+
+func TestClusterSettingsConflict(t *testing.T) {
+	srv, _ := NewTestServer()
+	defer srv.Close()
+
+	if status, reply := putClusterSettings(t, srv.URL, `{"persistent":{"a":"1","a.b":"2"}}`); status != http.StatusBadRequest || errorType(reply) != "illegal_argument_exception" {
+		t.Errorf("conflicting settings in one request returned %d %v, want 400 illegal_argument_exception", status, reply)
+	}
+	if status, reply := putClusterSettings(t, srv.URL, `{"persistent":{"a":{"b":"2"}}}`); status != http.StatusOK {
+		t.Fatalf("PUT a.b returned %d %v, want 200", status, reply)
+	}
+	if status, reply := putClusterSettings(t, srv.URL, `{"persistent":{"a":"1"}}`); status != http.StatusBadRequest || errorType(reply) != "illegal_argument_exception" {
+		t.Errorf("setting conflicting with a stored setting returned %d %v, want 400 illegal_argument_exception", status, reply)
+	}
+
+	resp, err := http.Get(srv.URL + "/_cluster/settings?flat_settings=true")
+	if err != nil {
+		t.Fatalf("GET /_cluster/settings failed: %s", err)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		Persistent map[string]any `json:"persistent"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatalf("GET /_cluster/settings reply doesn't decode: %s", err)
+	}
+	if len(reply.Persistent) != 1 || reply.Persistent["a.b"] != "2" {
+		t.Errorf("persistent settings are %v, want only a.b", reply.Persistent)
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// endpointStats counts the requests handled by each endpoint, and the bulk
+// actions as bulk_items.  The zero value is empty and ready to use.  It is
+// safe for concurrent use.
+type endpointStats struct {
+	counters sync.Map // endpoint name to *atomic.Int64
+}
+
+// add adds n to the counter of endpoint
+func (s *endpointStats) add(endpoint string, n int64) {
+	c, ok := s.counters.Load(endpoint)
+	if !ok {
+		c, _ = s.counters.LoadOrStore(endpoint, new(atomic.Int64))
+	}
+	c.(*atomic.Int64).Add(n)
+}
+
+// get returns a copy of the counters by endpoint name
+func (s *endpointStats) get() map[string]int64 {
+	c := make(map[string]int64)
+	s.counters.Range(func(k, v any) bool {
+		c[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+	return c
+}
+
+// Stats returns the number of requests handled by each endpoint, eg root
+// and bulk, and the number of bulk actions as bulk_items.  Endpoints that
+// were not requested are absent.
+func (h *APIHandler) Stats() map[string]int64 {
+	return h.stats.get()
+}
+
+// MockStats handles /_mock/stats get requests by returning Stats
+func (h *APIHandler) MockStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.Stats())
+	return
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DocumentStore keeps the documents sent with bulk requests in memory.
+// Each write of a document increments its version, and the sequence
+// number shared by all documents.  It is safe for concurrent use.
+type DocumentStore struct {
+	mu      sync.RWMutex
+	indices map[string]map[string]StoredDocument
+	size    int64
+	seqNo   int64
+}
+
+// NewDocumentStore returns an empty DocumentStore
+func NewDocumentStore() *DocumentStore {
+	return &DocumentStore{indices: make(map[string]map[string]StoredDocument)}
+}
+
+// Put stores doc with id in index, replacing any previous document with the same id
+func (s *DocumentStore) Put(index, id string, doc []byte) {
+	s.Index(index, id, doc)
+}
+
+// Index stores doc with id in index like Put, and returns the stored
+// document with its version and sequence number, and true if there was no
+// previous document with the same id
+func (s *DocumentStore) Index(index, id string, doc []byte) (StoredDocument, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs, ok := s.indices[index]
+	if !ok {
+		docs = make(map[string]StoredDocument)
+		s.indices[index] = docs
+	}
+	old, exists := docs[id]
+	if exists {
+		s.size -= int64(len(old.Source))
+	}
+	sd := StoredDocument{Index: index, ID: id, Source: append([]byte(nil), doc...), Version: old.Version + 1, SeqNo: s.seqNo}
+	s.seqNo++
+	docs[id] = sd
+	s.size += int64(len(doc))
+	return sd, !exists
+}
+
+// Get returns the document with id in index, and false if there is none
+func (s *DocumentStore) Get(index, id string) (StoredDocument, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sd, ok := s.indices[index][id]
+	return sd, ok
+}
+
+// Delete removes the document with id from index, returning true if it was present
+func (s *DocumentStore) Delete(index, id string) bool {
+	_, ok := s.Remove(index, id)
+	return ok
+}
+
+// Remove removes the document with id from index like Delete, and returns
+// the version and sequence number of the deletion, and true if it was
+// present
+func (s *DocumentStore) Remove(index, id string) (StoredDocument, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.indices[index][id]
+	if !ok {
+		return StoredDocument{}, false
+	}
+	delete(s.indices[index], id)
+	s.size -= int64(len(old.Source))
+	sd := StoredDocument{Index: index, ID: id, Version: old.Version + 1, SeqNo: s.seqNo}
+	s.seqNo++
+	return sd, true
+}
+
+// DeleteIndex removes all documents of index
+func (s *DocumentStore) DeleteIndex(index string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sd := range s.indices[index] {
+		s.size -= int64(len(sd.Source))
+	}
+	delete(s.indices, index)
+}
+
+// CountIndices returns the number of stored documents in the indices
+// matching a comma separated list of names, empty string or _all is every
+// index
+func (s *DocumentStore) CountIndices(names string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var docs int64
+	for index, d := range s.indices {
+		if names == "" || names == "_all" || matchName(names, index) {
+			docs += int64(len(d))
+		}
+	}
+	return docs
+}
+
+// StoredDocument is a document kept in a DocumentStore
+type StoredDocument struct {
+	Index   string
+	ID      string
+	Source  json.RawMessage
+	Version int64
+	SeqNo   int64
+}
+
+// Search returns size stored documents from offset from, in the indices
+// matching a comma separated list of names like CountIndices, and the
+// total number of documents in them.  Documents are sorted by index and id.
+func (s *DocumentStore) Search(names string, from, size int) ([]StoredDocument, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matched []StoredDocument
+	for index, d := range s.indices {
+		if names != "" && names != "_all" && !matchName(names, index) {
+			continue
+		}
+		for _, sd := range d {
+			matched = append(matched, sd)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Index != matched[j].Index {
+			return matched[i].Index < matched[j].Index
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	total := int64(len(matched))
+	from = min(max(from, 0), len(matched))
+	size = min(max(size, 0), len(matched)-from)
+	return matched[from : from+size], total
+}
+
+// Size returns the total number of bytes of all stored documents
+func (s *DocumentStore) Size() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+// Count returns the number of stored documents and the number of indices
+// they are in
+func (s *DocumentStore) Count() (docs int64, indices int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, d := range s.indices {
+		docs += int64(len(d))
+	}
+	return docs, len(s.indices)
+}
+
+// namedStore keeps JSON bodies by name, eg ingest pipelines and templates.  The zero
+// value is empty and ready to use.  It is safe for concurrent use.
+type namedStore struct {
+	mu     sync.RWMutex
+	bodies map[string]json.RawMessage
+}
+
+// put stores body with name, replacing any previous body with the same name
+func (s *namedStore) put(name string, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bodies == nil {
+		s.bodies = make(map[string]json.RawMessage)
+	}
+	s.bodies[name] = append(json.RawMessage(nil), body...)
+}
+
+// get returns the body stored with name, and whether it was present
+func (s *namedStore) get(name string) (json.RawMessage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	body, ok := s.bodies[name]
+	return body, ok
+}
+
+// all returns a copy of the stored bodies by name
+func (s *namedStore) all() map[string]json.RawMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := make(map[string]json.RawMessage, len(s.bodies))
+	for k, v := range s.bodies {
+		c[k] = v
+	}
+	return c
+}
+
+// delete removes the body stored with name, returning true if it was present
+func (s *namedStore) delete(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.bodies[name]
+	delete(s.bodies, name)
+	return ok
+}
+
+// match returns the sorted names matching a comma separated list of names,
+// which can contain * wildcards like Elasticsearch allows
+func (s *namedStore) match(names string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matched []string
+	for name := range s.bodies {
+		if matchName(names, name) {
+			matched = append(matched, name)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// matchName returns true if name matches a comma separated list of names,
+// which can contain * wildcards like Elasticsearch allows
+func matchName(names, name string) bool {
+	for _, pattern := range strings.Split(names, ",") {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tasksPath is the path of the task management API
+const tasksPath = "/_tasks"
+
+// TaskInfo describes a task in /_tasks replies
+type TaskInfo struct {
+	Node               string `json:"node"`
+	ID                 int64  `json:"id"`
+	Type               string `json:"type"`
+	Action             string `json:"action"`
+	Description        string `json:"description,omitempty"`
+	StartTimeInMillis  int64  `json:"start_time_in_millis"`
+	RunningTimeInNanos int64  `json:"running_time_in_nanos"`
+	Cancellable        bool   `json:"cancellable"`
+}
+
+// TaskNode is a node and its running tasks, keyed by task id
+type TaskNode struct {
+	Name             string              `json:"name"`
+	TransportAddress string              `json:"transport_address"`
+	Host             string              `json:"host"`
+	IP               string              `json:"ip"`
+	Roles            []string            `json:"roles"`
+	Tasks            map[string]TaskInfo `json:"tasks"`
+}
+
+// TasksResponse is the reply to /_tasks
+type TasksResponse struct {
+	Nodes map[string]TaskNode `json:"nodes"`
+}
+
+// TaskResponse is the reply to /_tasks/{task_id}, Response is the result
+// of a completed task
+type TaskResponse struct {
+	Completed bool     `json:"completed"`
+	Task      TaskInfo `json:"task"`
+	Response  any      `json:"response,omitempty"`
+}
+
+// task is a synthetic task, running for duration from start
+type task struct {
+	action      string
+	description string
+	start       time.Time
+	duration    time.Duration
+	response    any
+}
+
+// taskRegistry keeps the synthetic tasks by id.  The zero value is empty
+// and ready to use.  It is safe for concurrent use.
+type taskRegistry struct {
+	mu    sync.RWMutex
+	next  int64
+	tasks map[int64]task
+}
+
+// add records t and returns its id
+func (tr *taskRegistry) add(t task) int64 {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.tasks == nil {
+		tr.tasks = make(map[int64]task)
+	}
+	tr.next++
+	tr.tasks[tr.next] = t
+	return tr.next
+}
+
+// get returns the task with id, and whether it exists
+func (tr *taskRegistry) get(id int64) (task, bool) {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	t, ok := tr.tasks[id]
+	return t, ok
+}
+
+// running returns the ids of the tasks running at now
+func (tr *taskRegistry) running(now time.Time) []int64 {
+	tr.mu.RLock()
+	defer tr.mu.RUnlock()
+	var ids []int64
+	for id, t := range tr.tasks {
+		if now.Before(t.start.Add(t.duration)) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// matchAction returns true if action matches one of a comma separated list
+// of patterns, whose * wildcards also match the / of action names, eg
+// *reindex matches indices:data/write/reindex
+func matchAction(patterns, action string) bool {
+	for _, pattern := range strings.Split(patterns, ",") {
+		re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		if ok, _ := regexp.MatchString(re, action); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// StartTask starts a synthetic task running action for duration, listed by
+// /_tasks while it runs, and returns its id, eg
+// oTUltX4IQMOUUVeiohTt8A:1.  /_tasks/{task_id} then replies that the task
+// is completed, with response.
+func (h *APIHandler) StartTask(action, description string, duration time.Duration, response any) string {
+	id := h.tasks.add(task{action: action, description: description, start: time.Now(), duration: duration, response: response})
+	return fmt.Sprintf("%s:%d", h.UUID, id)
+}
+
+// taskInfo returns the TaskInfo of t with id at now
+func (h *APIHandler) taskInfo(id int64, t task, now time.Time) TaskInfo {
+	running := now.Sub(t.start)
+	if running > t.duration {
+		running = t.duration
+	}
+	return TaskInfo{
+		Node:               h.UUID.String(),
+		ID:                 id,
+		Type:               "transport",
+		Action:             t.action,
+		Description:        t.description,
+		StartTimeInMillis:  t.start.UnixMilli(),
+		RunningTimeInNanos: running.Nanoseconds(),
+		Cancellable:        true,
+	}
+}
+
+// Tasks handles /_tasks get requests by listing the running synthetic
+// tasks, which can be filtered by the actions parameter, and
+// /_tasks/{task_id} get requests by replying the task and whether it
+// completed
+func (h *APIHandler) Tasks(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(tasksTotalMetrics, h.metricsRegistry)
+	h.stats.add("tasks", 1)
+	now := time.Now()
+	var reply any
+	if taskID, ok := strings.CutPrefix(r.URL.Path, tasksPath+"/"); ok {
+		node, n, _ := strings.Cut(taskID, ":")
+		id, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("malformed task id %s", taskID))
+			return
+		}
+		t, ok := h.tasks.get(id)
+		if !ok || node != h.UUID.String() {
+			h.writeError(w, http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("task [%s] isn't running and hasn't stored its results", taskID))
+			return
+		}
+		tr := TaskResponse{Completed: !now.Before(t.start.Add(t.duration)), Task: h.taskInfo(id, t, now)}
+		if tr.Completed {
+			tr.Response = t.response
+		}
+		reply = tr
+	} else {
+		actions := r.URL.Query().Get("actions")
+		tasks := make(map[string]TaskInfo)
+		for _, id := range h.tasks.running(now) {
+			t, _ := h.tasks.get(id)
+			if actions != "" && !matchAction(actions, t.action) {
+				continue
+			}
+			tasks[fmt.Sprintf("%s:%d", h.UUID, id)] = h.taskInfo(id, t, now)
+		}
+		reply = TasksResponse{Nodes: map[string]TaskNode{
+			h.UUID.String(): {
+				Name:             nodeName,
+				TransportAddress: "127.0.0.1:9300",
+				Host:             "127.0.0.1",
+				IP:               "127.0.0.1",
+				Roles:            []string{"data", "ingest", "master"},
+				Tasks:            tasks,
+			},
+		}}
+	}
+	writeJSON(w, http.StatusOK, reply)
+	return
+}
+
+// MockTask handles /_mock/task post requests by starting a synthetic task
+// running the action parameter, indices:data/write/reindex by default, for
+// the duration parameter, 1m by default, and replying its id
+func (h *APIHandler) MockTask(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	action := q.Get("action")
+	if action == "" {
+		action = "indices:data/write/reindex"
+	}
+	duration := time.Minute
+	if d := q.Get("duration"); d != "" {
+		var err error
+		if duration, err = time.ParseDuration(d); err != nil || duration < 0 {
+			h.writeError(w, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("failed to parse duration [%s]", d))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"task": h.StartTask(action, q.Get("description"), duration, nil)})
+	return
+}
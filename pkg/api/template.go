@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// indexTemplatePath is the path of the composable index template endpoints
+	indexTemplatePath = "/_index_template"
+	// legacyTemplatePath is the path of the legacy index template endpoints
+	legacyTemplatePath = "/_template"
+)
+
+// IndexTemplateItem is a composable index template in a get response
+type IndexTemplateItem struct {
+	Name          string          `json:"name"`
+	IndexTemplate json.RawMessage `json:"index_template"`
+}
+
+// IndexTemplate handles /_index_template/{name} put requests by storing the
+// template, get and head requests by returning the templates matching name,
+// and delete requests by removing them.  Like Elasticsearch, name can be a
+// comma separated list with * wildcards, so all templates are removed with
+// DELETE /_index_template/*.
+func (h *APIHandler) IndexTemplate(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(indexTemplateTotalMetrics, h.metricsRegistry)
+	h.stats.add("index_template", 1)
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, indexTemplatePath), "/")
+	switch r.Method {
+	case http.MethodPut:
+		h.putTemplate(w, r, &h.indexTemplates, name)
+		return
+	case http.MethodDelete:
+		if !deleteTemplates(&h.indexTemplates, name) {
+			h.writeError(w, http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("index_template matching [%s] not found", name))
+			return
+		}
+		writeAcknowledged(w)
+		return
+	}
+
+	if name == "" {
+		name = "*"
+	}
+	names := h.indexTemplates.match(name)
+	if len(names) == 0 && !strings.Contains(name, "*") {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.writeError(w, http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("index template matching [%s] not found", name))
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	items := make([]IndexTemplateItem, 0, len(names))
+	for _, n := range names {
+		body, _ := h.indexTemplates.get(n)
+		items = append(items, IndexTemplateItem{Name: n, IndexTemplate: body})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"index_templates": items})
+	return
+}
+
+// putTemplate stores the template in the body of r with name in templates
+func (h *APIHandler) putTemplate(w http.ResponseWriter, r *http.Request, templates *namedStore, name string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("error reading template body: %s", err)
+		return
+	}
+	var template map[string]any
+	if err := json.Unmarshal(body, &template); err != nil {
+		h.writeError(w, http.StatusBadRequest, "parse_exception", fmt.Sprintf("request body is required to be a JSON object: %s", err))
+		return
+	}
+	templates.put(name, body)
+	writeAcknowledged(w)
+}
+
+// deleteTemplates removes the templates matching name from templates.  It
+// returns false if name has no wildcards and there is no such template.
+func deleteTemplates(templates *namedStore, name string) bool {
+	names := templates.match(name)
+	if len(names) == 0 && !strings.Contains(name, "*") {
+		return false
+	}
+	for _, n := range names {
+		templates.delete(n)
+	}
+	return true
+}
+
+// LegacyTemplate handles /_template/{name} requests like IndexTemplate does
+// for composable templates, replying with the legacy template format
+func (h *APIHandler) LegacyTemplate(w http.ResponseWriter, r *http.Request) {
+	incrementCounter(legacyTemplateTotalMetrics, h.metricsRegistry)
+	h.stats.add("legacy_template", 1)
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, legacyTemplatePath), "/")
+	switch r.Method {
+	case http.MethodPut:
+		h.putTemplate(w, r, &h.legacyTemplates, name)
+		return
+	case http.MethodDelete:
+		if !deleteTemplates(&h.legacyTemplates, name) {
+			h.writeError(w, http.StatusNotFound, "index_template_missing_exception", fmt.Sprintf("index_template [%s] missing", name))
+			return
+		}
+		writeAcknowledged(w)
+		return
+	}
+
+	if name == "" {
+		name = "*"
+	}
+	names := h.legacyTemplates.match(name)
+	status := http.StatusOK
+	if len(names) == 0 && !strings.Contains(name, "*") {
+		status = http.StatusNotFound
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	templates := make(map[string]json.RawMessage, len(names))
+	for _, n := range names {
+		templates[n], _ = h.legacyTemplates.get(n)
+	}
+	writeJSON(w, status, templates)
+	return
+}
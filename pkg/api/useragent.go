@@ -0,0 +1,147 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/mileusna/useragent"
+)
+
+const (
+	// unknownUserAgent is used for user agents without a name and version
+	unknownUserAgent = "unknown"
+	// otherUserAgent is used for the user agents seen after MaxAgents
+	// distinct ones
+	otherUserAgent = "other"
+)
+
+// DefaultMaxUserAgents is the number of distinct user agents tracked when
+// MaxAgents is not set
+const DefaultMaxUserAgents = 100
+
+// UserAgentMaps has the number of requests per normalized user agent for
+// each endpoint, and the number of bulk actions per normalized user agent
+// for each action
+type UserAgentMaps struct {
+	Root    map[string]int64 `json:"root"`
+	License map[string]int64 `json:"license"`
+	Bulk    map[string]int64 `json:"bulk"`
+	Index   map[string]int64 `json:"index"`
+	Create  map[string]int64 `json:"create"`
+	Update  map[string]int64 `json:"update"`
+	Delete  map[string]int64 `json:"delete"`
+}
+
+// UserAgentTracker counts the user agents seen by each endpoint.  It is
+// safe for concurrent use.
+type UserAgentTracker struct {
+	// MaxAgents is the number of distinct user agents counted, later ones
+	// are counted as "other".  0 is DefaultMaxUserAgents.
+	MaxAgents int
+	mu        sync.Mutex
+	maps      UserAgentMaps
+	agents    map[string]struct{}
+}
+
+// NewUserAgentTracker returns an empty UserAgentTracker
+func NewUserAgentTracker() *UserAgentTracker {
+	return &UserAgentTracker{agents: make(map[string]struct{}), maps: UserAgentMaps{
+		Root:    make(map[string]int64),
+		License: make(map[string]int64),
+		Bulk:    make(map[string]int64),
+		Index:   make(map[string]int64),
+		Create:  make(map[string]int64),
+		Update:  make(map[string]int64),
+		Delete:  make(map[string]int64),
+	}}
+}
+
+// SeenRoot counts a request to the / endpoint by agent
+func (t *UserAgentTracker) SeenRoot(agent string) {
+	t.seen(t.maps.Root, agent)
+}
+
+// SeenLicense counts a request to the /_license endpoint by agent
+func (t *UserAgentTracker) SeenLicense(agent string) {
+	t.seen(t.maps.License, agent)
+}
+
+// SeenBulk counts a request to the _bulk endpoints by agent
+func (t *UserAgentTracker) SeenBulk(agent string) {
+	t.seen(t.maps.Bulk, agent)
+}
+
+// SeenIndex counts a bulk index action by agent
+func (t *UserAgentTracker) SeenIndex(agent string) {
+	t.seen(t.maps.Index, agent)
+}
+
+// SeenCreate counts a bulk create action by agent
+func (t *UserAgentTracker) SeenCreate(agent string) {
+	t.seen(t.maps.Create, agent)
+}
+
+// SeenUpdate counts a bulk update action by agent
+func (t *UserAgentTracker) SeenUpdate(agent string) {
+	t.seen(t.maps.Update, agent)
+}
+
+// SeenDelete counts a bulk delete action by agent
+func (t *UserAgentTracker) SeenDelete(agent string) {
+	t.seen(t.maps.Delete, agent)
+}
+
+func (t *UserAgentTracker) seen(m map[string]int64, agent string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m[agent]++
+}
+
+// Get returns a copy of the counts
+func (t *UserAgentTracker) Get() UserAgentMaps {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return UserAgentMaps{
+		Root:    cloneCounts(t.maps.Root),
+		License: cloneCounts(t.maps.License),
+		Bulk:    cloneCounts(t.maps.Bulk),
+		Index:   cloneCounts(t.maps.Index),
+		Create:  cloneCounts(t.maps.Create),
+		Update:  cloneCounts(t.maps.Update),
+		Delete:  cloneCounts(t.maps.Delete),
+	}
+}
+
+func cloneCounts(m map[string]int64) map[string]int64 {
+	c := make(map[string]int64, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// normalize reduces a User-Agent header to the parsed name and major and
+// minor version, eg "Elastic-filebeat/8.12", to keep the number of
+// distinct values small.  Agents without a name and version are "unknown",
+// and agents seen after MaxAgents distinct ones are "other", so fuzzers
+// can't create an unbounded number of metrics.
+func (t *UserAgentTracker) normalize(header string) string {
+	ua := useragent.Parse(header)
+	if ua.Name == "" || ua.Version == "" {
+		return unknownUserAgent
+	}
+	agent := ua.Name + "/" + ua.VersionNoShort()
+	maxAgents := t.MaxAgents
+	if maxAgents <= 0 {
+		maxAgents = DefaultMaxUserAgents
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.agents[agent]; ok {
+		return agent
+	}
+	if len(t.agents) >= maxAgents {
+		return otherUserAgent
+	}
+	t.agents[agent] = struct{}{}
+	return agent
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultBuildDate is the build_date of / when BuildDate is not set
+const defaultBuildDate = "2024-01-01T00:00:00.000000000Z"
+
+// RootResponse is the reply to /
+type RootResponse struct {
+	Name        string      `json:"name"`
+	ClusterName string      `json:"cluster_name"`
+	ClusterUUID string      `json:"cluster_uuid"`
+	Version     VersionInfo `json:"version"`
+	Tagline     string      `json:"tagline"`
+}
+
+// VersionInfo is the version object of the reply to /
+type VersionInfo struct {
+	Number                           string `json:"number"`
+	BuildFlavor                      string `json:"build_flavor"`
+	BuildType                        string `json:"build_type"`
+	BuildHash                        string `json:"build_hash"`
+	BuildDate                        string `json:"build_date"`
+	BuildSnapshot                    bool   `json:"build_snapshot"`
+	LuceneVersion                    string `json:"lucene_version"`
+	MinimumWireCompatibilityVersion  string `json:"minimum_wire_compatibility_version"`
+	MinimumIndexCompatibilityVersion string `json:"minimum_index_compatibility_version"`
+}
+
+// compatibility is the Lucene version and the minimum wire and index
+// compatibility versions of an Elasticsearch major version
+type compatibility struct {
+	lucene string
+	wire   string
+	index  string
+}
+
+// compatibilities are those of the last minor of each major version, which
+// are used for its other minors too
+var compatibilities = map[int]compatibility{
+	6: {lucene: "7.7.3", wire: "5.6.0", index: "5.0.0"},
+	7: {lucene: "8.11.3", wire: "6.8.0", index: "6.0.0-beta1"},
+	8: {lucene: "9.12.0", wire: "7.17.0", index: "7.0.0"},
+	9: {lucene: "10.1.0", wire: "8.18.0", index: "8.0.0"},
+}
+
+// versionInfo returns the version object of / for version.  The build
+// hash, build date and Lucene version are BuildHash, BuildDate and
+// LuceneVersion, or defaults derived from version when they are not set.
+func (h *APIHandler) versionInfo(version string) VersionInfo {
+	major, _ := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	c, ok := compatibilities[major]
+	if !ok {
+		c = compatibilities[8]
+	}
+	vi := VersionInfo{
+		Number:                           version,
+		BuildFlavor:                      "default",
+		BuildType:                        "docker",
+		BuildHash:                        h.BuildHash,
+		BuildDate:                        h.BuildDate,
+		BuildSnapshot:                    strings.HasSuffix(version, "-SNAPSHOT"),
+		LuceneVersion:                    h.LuceneVersion,
+		MinimumWireCompatibilityVersion:  c.wire,
+		MinimumIndexCompatibilityVersion: c.index,
+	}
+	if vi.BuildHash == "" {
+		// a made up hash that is stable for each version
+		sum := sha1.Sum([]byte(version))
+		vi.BuildHash = hex.EncodeToString(sum[:])
+	}
+	if vi.BuildDate == "" {
+		vi.BuildDate = defaultBuildDate
+	}
+	if vi.LuceneVersion == "" {
+		vi.LuceneVersion = c.lucene
+	}
+	return vi
+}
+
+// rootResponse returns the reply to / of r
+func (h *APIHandler) rootResponse(r *http.Request) RootResponse {
+	return RootResponse{
+		Name:        nodeName,
+		ClusterName: h.ClusterName,
+		ClusterUUID: h.ClusterUUID,
+		Version:     h.versionInfo(h.version(r)),
+		Tagline:     "You Know, for Search",
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// rootVersion returns the version object of the reply to GET / of url
+func rootVersion(t *testing.T, url string) map[string]any {
+	t.Helper()
+	resp, err := http.Get(url + "/")
+	if err != nil {
+		t.Fatalf("GET / failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET / status is %d, want 200", resp.StatusCode)
+	}
+	var reply map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatalf("GET / reply doesn't decode: %s", err)
+	}
+	version, ok := reply["version"].(map[string]any)
+	if !ok {
+		t.Fatalf("GET / reply %v has no version object", reply)
+	}
+	return version
+}
+
+func TestRootVersionDefaults(t *testing.T) {
+	srv, _ := NewTestServer(WithVersion("8.15.0"))
+	defer srv.Close()
+
+	v := rootVersion(t, srv.URL)
+	if v["number"] != "8.15.0" {
+		t.Errorf("number is %v, want 8.15.0", v["number"])
+	}
+	if hash, _ := v["build_hash"].(string); len(hash) != 40 {
+		t.Errorf("build_hash %v is not a 40 characters git hash", v["build_hash"])
+	}
+	date, _ := v["build_date"].(string)
+	if _, err := time.Parse(time.RFC3339Nano, date); err != nil {
+		t.Errorf("build_date %v doesn't parse: %s", v["build_date"], err)
+	}
+	if v["build_snapshot"] != false {
+		t.Errorf("build_snapshot is %v, want false for a release", v["build_snapshot"])
+	}
+	if v["lucene_version"] != "9.12.0" {
+		t.Errorf("lucene_version is %v, want 9.12.0", v["lucene_version"])
+	}
+}
+
+func TestRootVersionBuildInfo(t *testing.T) {
+	srv, _ := NewTestServer(WithVersion("9.1.0-SNAPSHOT"), WithBuildInfo("abc123", "2025-06-01T10:00:00.000Z", "10.2.1"))
+	defer srv.Close()
+
+	v := rootVersion(t, srv.URL)
+	for field, want := range map[string]any{
+		"number":         "9.1.0-SNAPSHOT",
+		"build_hash":     "abc123",
+		"build_date":     "2025-06-01T10:00:00.000Z",
+		"build_snapshot": true,
+		"lucene_version": "10.2.1",
+	} {
+		if v[field] != want {
+			t.Errorf("%s is %v, want %v", field, v[field], want)
+		}
+	}
+}
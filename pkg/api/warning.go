@@ -0,0 +1,47 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// bulkTypesDeprecation is the warning of a bulk request with a _type in its
+// action metadata
+const bulkTypesDeprecation = "[types removal] Specifying types in bulk requests is deprecated."
+
+// deprecatedParams are the warnings of the deprecated query parameters,
+// keyed by parameter
+var deprecatedParams = map[string]string{
+	"include_type_name": "[types removal] Using include_type_name in requests is deprecated. The parameter will be removed in the next major version.",
+}
+
+// addWarning adds a Warning header with message to the response to r, in
+// the format of the deprecation warnings of Elasticsearch, eg
+// 299 Elasticsearch-8.15.0-1a2b3c "message"
+func (h *APIHandler) addWarning(w http.ResponseWriter, r *http.Request, message string) {
+	incrementCounter(deprecationWarningMetrics, h.metricsRegistry)
+	version := h.version(r)
+	message = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(message)
+	w.Header().Add(http.CanonicalHeaderKey("Warning"), fmt.Sprintf(`299 Elasticsearch-%s-%s "%s"`, version, h.versionInfo(version).BuildHash, message))
+}
+
+// addWarnings adds the Warnings and the warnings of the deprecated query
+// parameters of r to the response
+func (h *APIHandler) addWarnings(w http.ResponseWriter, r *http.Request) {
+	for _, message := range h.Warnings {
+		h.addWarning(w, r, message)
+	}
+	q := r.URL.Query()
+	for param, message := range deprecatedParams {
+		if q.Has(param) {
+			h.addWarning(w, r, message)
+		}
+	}
+}
+
+// hasType returns true if the metadata of a has the deprecated _type
+func hasType(a *bulkAction) bool {
+	_, ok := a.meta["_type"]
+	return ok
+}